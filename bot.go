@@ -0,0 +1,15 @@
+package dumbirc
+
+// HandleBotMode marks us as a bot with the user mode the server
+// advertises in ISUPPORT BOT once registration completes
+func (c *Connection) HandleBotMode() {
+	setMode := func(m *Message) {
+		mode := c.ISupport().Bot
+		if mode == "" {
+			return
+		}
+		c.send("MODE " + c.prefixName() + " +" + mode)
+	}
+	c.AddCallback(ENDOFMOTD, setMode)
+	c.AddCallback(NOMOTD, setMode)
+}
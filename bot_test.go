@@ -0,0 +1,80 @@
+package dumbirc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+func TestSplitTags(t *testing.T) {
+	tags, rest := splitTags(`@bot;+typing=active;msg=a\sb\:c\\d\ :nick!u@h TAGMSG #test`)
+	expected := map[string]string{"bot": "", "+typing": "active", "msg": `a b;c\d`}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, tags)
+	}
+	if rest != ":nick!u@h TAGMSG #test" {
+		t.Errorf("expected rest %q, got %q", ":nick!u@h TAGMSG #test", rest)
+	}
+	if tags, _ := splitTags(":nick!u@h PRIVMSG #test :hi"); tags != nil {
+		t.Errorf("expected no tags, got %v", tags)
+	}
+}
+
+func TestHandleBotMode(t *testing.T) {
+	tt := []*irc.Message{
+		irc.ParseMessage(fmt.Sprintf("USER %s +iw * %s", nick, nick)),
+		irc.ParseMessage(fmt.Sprintf("NICK %s", nick)),
+		irc.ParseMessage(fmt.Sprintf("MODE %s +B", nick)),
+	}
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.HandleBotMode()
+	bots := make(chan bool, 2)
+	bot.AddCallback(PRIVMSG, func(m *Message) {
+		bots <- m.Bot
+	})
+	bot.Start()
+	srv.encode(fmt.Sprintf(":example.com 005 %s BOT=B NICKLEN=30 :are supported by this server", nick))
+	srv.encode(fmt.Sprintf(":example.com 376 %s :End of /MOTD command.", nick))
+	for _, tc := range tt {
+		msg, err := srv.decode()
+		if err != nil {
+			t.Errorf("decoding a message failed: %v", err)
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(tc, msg) {
+			t.Errorf("expected %v, got %v", tc, msg)
+		}
+	}
+	srv.raw("@bot :other!other@example.com PRIVMSG #test :hi")
+	if !<-bots {
+		t.Error("expected message with bot tag to be from a bot")
+	}
+	srv.encode(fmt.Sprintf(":%s!%s@example.com JOIN #test", nick, nick))
+	srv.encode(fmt.Sprintf(":example.com 353 %s = #test :%s human robot", nick, nick))
+	srv.encode(fmt.Sprintf(":example.com 352 %s #test user example.com irc.example.com human H :0 Human", nick))
+	srv.encode(":human!user@example.com PRIVMSG #test :hi")
+	if <-bots {
+		t.Error("expected message without bot flags not to be from a bot")
+	}
+	srv.encode(fmt.Sprintf(":example.com 352 %s #test user example.com irc.example.com robot HB :0 Robot", nick))
+	srv.encode(":robot!user@example.com PRIVMSG #test :hi")
+	if !<-bots {
+		t.Error("expected message from a user with bot WHO flags to be from a bot")
+	}
+	// not in our channels, only the bot flag is remembered
+	srv.encode(fmt.Sprintf(":example.com 352 %s * user example.com irc.example.com helper HB :0 Helper", nick))
+	srv.encode(fmt.Sprintf(":helper!user@example.com PRIVMSG %s :hi", nick))
+	if !<-bots {
+		t.Error("expected a private message from a WHO bot to be from a bot")
+	}
+	if u := bot.LookupUser("helper"); u != nil {
+		t.Errorf("expected helper not to be tracked, got %+v", u)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,76 @@
+package dumbirc
+
+import (
+	"sync/atomic"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// CancelTarget drops the lines still queued for a channel or nick,
+// e.g. the rest of a long listing, and returns how many were dropped
+func (c *Connection) CancelTarget(target string) int {
+	return c.queue.remove(c.Fold(target), false, func(outLine) bool {
+		return true
+	})
+}
+
+// FlushQueue drops everything that is still queued
+func (c *Connection) FlushQueue() int {
+	return c.queue.remove("", true, func(outLine) bool {
+		return true
+	})
+}
+
+// SendGroup tags the lines sent through it so they can be cancelled together
+type SendGroup struct {
+	c  *Connection
+	id uint64
+}
+
+// NewSendGroup returns a new group, e.g. for the output of one command
+func (c *Connection) NewSendGroup() *SendGroup {
+	return &SendGroup{c: c, id: uint64(atomic.AddUint32(&c.groups, 1))}
+}
+
+// Msg sends a privmessage as part of the group
+func (g *SendGroup) Msg(dest, msg string) {
+	lines, err := g.c.msgLines(irc.PRIVMSG, dest, msg)
+	g.c.sendLogged(PriorityNormal, g.id, lines, err)
+}
+
+// Notice sends a notice as part of the group
+func (g *SendGroup) Notice(dest, msg string) {
+	lines, err := g.c.msgLines(irc.NOTICE, dest, msg)
+	g.c.sendLogged(PriorityNormal, g.id, lines, err)
+}
+
+// Cmd sends a raw command as part of the group
+func (g *SendGroup) Cmd(command string) {
+	g.c.sendLogged(priorityOf(command), g.id, []string{command}, nil)
+}
+
+// Cancel drops the lines of the group that are still queued
+// and returns how many were dropped
+func (g *SendGroup) Cancel() int {
+	return g.c.queue.remove("", true, func(v outLine) bool {
+		return v.group == g.id
+	})
+}
+
+// dropChannelOutput drops what is queued for a channel we have left
+func (c *Connection) dropChannelOutput() {
+	c.addHandler(irc.PART, func(m *Message) {
+		if len(m.Params) > 0 && c.fromMe(m) {
+			if n := c.CancelTarget(m.Params[0]); n > 0 {
+				c.Log.Printf("left %s, dropped %d queued lines", m.Params[0], n)
+			}
+		}
+	})
+	c.addHandler(KICK, func(m *Message) {
+		if len(m.Params) > 1 && c.EqualFold(m.Params[1], c.CurrentNick()) {
+			if n := c.CancelTarget(m.Params[0]); n > 0 {
+				c.Log.Printf("kicked from %s, dropped %d queued lines", m.Params[0], n)
+			}
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package dumbirc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueRemove(t *testing.T) {
+	q := newOutQueue()
+	q.pushGroup(PriorityNormal, "#a", 1, "a1", "a2")
+	q.pushGroup(PriorityBulk, "#a", 0, "a3")
+	q.pushGroup(PriorityNormal, "#b", 1, "b1")
+	q.pushGroup(PriorityNormal, "#b", 2, "b2")
+	if n := q.remove("#a", false, func(outLine) bool { return true }); n != 3 {
+		t.Errorf("expected 3 lines dropped for #a, got %d", n)
+	}
+	if n := q.remove("", true, func(v outLine) bool { return v.group == 1 }); n != 1 {
+		t.Errorf("expected 1 line dropped for the group, got %d", n)
+	}
+	if line, ok := q.pop(); !ok || line.line != "b2" {
+		t.Errorf("expected b2, got %s", line.line)
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("expected an empty queue")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(time.Second)
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	bot.Msg("#first", "sent at once")
+	srv.decode()
+	g := bot.NewSendGroup()
+	for i := 0; i < 3; i++ {
+		g.Msg("#test", "listing")
+		bot.Msg("#other", "other")
+		bot.Msg("#kicked", "kicked")
+	}
+	bot.Notice("#test", "not in the group")
+	if n := g.Cancel(); n != 3 {
+		t.Errorf("expected 3 group lines dropped, got %d", n)
+	}
+	srv.encode(fmt.Sprintf(":op!op@host KICK #kicked %s :bye", nick))
+	syncBot(srv, done)
+	if n := bot.QueueLen(); n != 4 {
+		t.Errorf("expected 4 lines left, got %d", n)
+	}
+	if n := bot.CancelTarget("#OTHER"); n != 3 {
+		t.Errorf("expected 3 lines dropped for #other, got %d", n)
+	}
+	if n := bot.FlushQueue(); n != 1 {
+		t.Errorf("expected 1 line flushed, got %d", n)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,196 @@
+package dumbirc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+type capState struct {
+	mu          sync.Mutex
+	available   map[string]string
+	enabled     map[string]bool
+	ls          []string
+	negotiating bool
+	pending     int
+}
+
+func newCapState() *capState {
+	s := &capState{}
+	s.reset()
+	return s
+}
+
+func (s *capState) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.available = make(map[string]string)
+	s.enabled = make(map[string]bool)
+	s.ls = nil
+	s.negotiating = false
+	s.pending = 0
+}
+
+func (s *capState) setNegotiating(v bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.negotiating = v
+}
+
+// HasCap reports whether the capability has been acknowledged by the server
+func (c *Connection) HasCap(name string) bool {
+	c.caps.mu.Lock()
+	defer c.caps.mu.Unlock()
+	return c.caps.enabled[name]
+}
+
+// CapValue returns the value the server advertised for a capability,
+// ok is false when the server does not offer it
+func (c *Connection) CapValue(name string) (value string, ok bool) {
+	c.caps.mu.Lock()
+	defer c.caps.mu.Unlock()
+	value, ok = c.caps.available[name]
+	return
+}
+
+// wantedCaps returns the offered capabilities we want that are not yet enabled
+func (c *Connection) wantedCaps(offered []string) (req []string) {
+	c.caps.mu.Lock()
+	defer c.caps.mu.Unlock()
+	for _, name := range offered {
+		if c.caps.enabled[name] {
+			continue
+		}
+		if name == "cap-notify" {
+			req = append(req, name)
+			continue
+		}
+		for _, want := range c.Caps {
+			if want == name {
+				req = append(req, name)
+				break
+			}
+		}
+	}
+	return req
+}
+
+// requestCaps sends CAP REQ lines and returns how many were sent
+func (c *Connection) requestCaps(caps []string) (n int) {
+	line := ""
+	for _, name := range caps {
+		if line != "" && len(line)+len(name)+len("CAP REQ :")+1 > 510 {
+			c.send("CAP REQ :" + line)
+			n++
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += name
+	}
+	if line != "" {
+		c.send("CAP REQ :" + line)
+		n++
+	}
+	return n
+}
+
+// capList parses "name=value name2" into a map
+func capList(list string) map[string]string {
+	caps := make(map[string]string)
+	for _, v := range strings.Fields(list) {
+		if i := strings.IndexByte(v, '='); i >= 0 {
+			caps[v[:i]] = v[i+1:]
+		} else {
+			caps[v] = ""
+		}
+	}
+	return caps
+}
+
+func (c *Connection) endCaps() {
+	c.caps.mu.Lock()
+	end := c.caps.negotiating && c.caps.pending <= 0
+	if end {
+		c.caps.negotiating = false
+	}
+	c.caps.mu.Unlock()
+	if end {
+		c.send("CAP " + irc.CAP_END)
+	}
+}
+
+// handleCaps negotiates the wanted capabilities on connect
+// and follows cap-notify changes after registration
+func (c *Connection) handleCaps() {
+	c.addHandler(irc.CAP, func(m *Message) {
+		if len(m.Params) < 3 {
+			return
+		}
+		list := m.Trailing()
+		switch strings.ToUpper(m.Params[1]) {
+		case irc.CAP_LS:
+			c.caps.mu.Lock()
+			c.caps.ls = append(c.caps.ls, list)
+			// "CAP * LS * :..." means more lines follow
+			if len(m.Params) > 3 && m.Params[2] == "*" {
+				c.caps.mu.Unlock()
+				return
+			}
+			offered := capList(strings.Join(c.caps.ls, " "))
+			c.caps.ls = nil
+			names := make([]string, 0, len(offered))
+			for k, v := range offered {
+				c.caps.available[k] = v
+				names = append(names, k)
+			}
+			c.caps.mu.Unlock()
+			sort.Strings(names)
+			n := c.requestCaps(c.wantedCaps(names))
+			c.caps.mu.Lock()
+			c.caps.pending += n
+			c.caps.mu.Unlock()
+			c.endCaps()
+		case irc.CAP_ACK:
+			c.caps.mu.Lock()
+			for _, v := range strings.Fields(list) {
+				if strings.HasPrefix(v, "-") {
+					delete(c.caps.enabled, v[1:])
+					continue
+				}
+				c.caps.enabled[v] = true
+			}
+			c.caps.pending--
+			c.caps.mu.Unlock()
+			c.endCaps()
+		case irc.CAP_NAK:
+			c.caps.mu.Lock()
+			c.caps.pending--
+			c.caps.mu.Unlock()
+			c.endCaps()
+		case "NEW":
+			offered := capList(list)
+			names := make([]string, 0, len(offered))
+			c.caps.mu.Lock()
+			for k, v := range offered {
+				c.caps.available[k] = v
+				names = append(names, k)
+			}
+			c.caps.mu.Unlock()
+			sort.Strings(names)
+			c.requestCaps(c.wantedCaps(names))
+			c.emit(CAPNEW, m)
+		case "DEL":
+			c.caps.mu.Lock()
+			for k := range capList(list) {
+				delete(c.caps.available, k)
+				delete(c.caps.enabled, k)
+			}
+			c.caps.mu.Unlock()
+			c.emit(CAPDEL, m)
+		}
+	})
+}
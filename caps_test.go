@@ -0,0 +1,61 @@
+package dumbirc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+func TestCaps(t *testing.T) {
+	tt := []*irc.Message{
+		irc.ParseMessage("CAP LS 302"),
+		irc.ParseMessage(fmt.Sprintf("USER %s +iw * %s", nick, nick)),
+		irc.ParseMessage(fmt.Sprintf("NICK %s", nick)),
+		irc.ParseMessage("CAP REQ :cap-notify multi-prefix"),
+		irc.ParseMessage("CAP END"),
+		irc.ParseMessage("CAP REQ :away-notify"),
+	}
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.Caps = []string{"multi-prefix", "away-notify"}
+	deleted := make(chan *Message)
+	bot.AddCallback(CAPDEL, func(m *Message) {
+		deleted <- m
+	})
+	bot.Start()
+	srv.encode("CAP * LS * :sasl=PLAIN multi-prefix")
+	srv.encode("CAP * LS :cap-notify")
+	for i, tc := range tt {
+		msg, err := srv.decode()
+		if err != nil {
+			t.Errorf("decoding a message failed: %v", err)
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(tc, msg) {
+			t.Errorf("expected %v, got %v", tc, msg)
+		}
+		switch i {
+		case 3:
+			srv.encode("CAP * ACK :cap-notify multi-prefix")
+		case 4:
+			srv.encode(fmt.Sprintf("CAP %s NEW :away-notify", nick))
+		}
+	}
+	if !bot.HasCap("multi-prefix") {
+		t.Error("expected multi-prefix to be enabled")
+	}
+	if v, ok := bot.CapValue("sasl"); !ok || v != "PLAIN" {
+		t.Errorf("expected sasl=PLAIN to be available, got %q %v", v, ok)
+	}
+	srv.encode(fmt.Sprintf("CAP %s DEL :multi-prefix", nick))
+	<-deleted
+	if bot.HasCap("multi-prefix") {
+		t.Error("expected multi-prefix to be disabled")
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
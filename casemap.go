@@ -0,0 +1,63 @@
+package dumbirc
+
+import "strings"
+
+// CaseFold folds s to lower case per the casemapping, one of
+// "ascii", "rfc1459", "strict-rfc1459" or "rfc7613".
+// Unknown casemappings are treated as rfc1459, the IRC default.
+// rfc7613 is only a lower casing approximation, the width mapping and
+// NFC normalization of RFC 7613 are not applied
+func CaseFold(casemapping, s string) string {
+	switch strings.ToLower(casemapping) {
+	case "ascii":
+		return foldASCII(s, 'Z')
+	case "strict-rfc1459":
+		return foldASCII(s, ']')
+	case "rfc7613":
+		return strings.ToLower(s)
+	default:
+		return foldASCII(s, '^')
+	}
+}
+
+// CaseEqual compares a and b per the casemapping
+func CaseEqual(casemapping, a, b string) bool {
+	return CaseFold(casemapping, a) == CaseFold(casemapping, b)
+}
+
+// foldASCII lower cases A-Z and the rfc1459 specials up to last:
+// '[' '\' ']' for strict-rfc1459, also '^' for rfc1459
+func foldASCII(s string, last byte) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= last {
+			if b == nil {
+				b = []byte(s)
+			}
+			b[i] = ch + 32
+		}
+	}
+	if b == nil {
+		return s
+	}
+	return string(b)
+}
+
+// caseMapping returns the server's casemapping without copying ISupport,
+// it is needed for nearly every line
+func (c *Connection) caseMapping() string {
+	c.isupportMu.Lock()
+	defer c.isupportMu.Unlock()
+	return c.isupport.CaseMapping
+}
+
+// Fold folds a nick or channel name with the server's casemapping
+func (c *Connection) Fold(s string) string {
+	return CaseFold(c.caseMapping(), s)
+}
+
+// EqualFold compares nicks or channel names with the server's casemapping
+func (c *Connection) EqualFold(a, b string) bool {
+	return CaseEqual(c.caseMapping(), a, b)
+}
@@ -0,0 +1,59 @@
+package dumbirc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+func TestCaseFold(t *testing.T) {
+	tt := []struct {
+		mapping, in, expected string
+	}{
+		{"ascii", "UgJka[]\\^", "ugjka[]\\^"},
+		{"rfc1459", "UgJka[]\\^", "ugjka{}|~"},
+		{"strict-rfc1459", "UgJka[]\\^", "ugjka{}|^"},
+		{"rfc7613", "ÜgJka[]", "ügjka[]"},
+		{"", "UgJka[]\\^", "ugjka{}|~"},
+	}
+	for _, tc := range tt {
+		if got := CaseFold(tc.mapping, tc.in); got != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.mapping, tc.expected, got)
+		}
+	}
+	if !CaseEqual("rfc1459", "nick[away]", "NICK{AWAY}") {
+		t.Error("expected rfc1459 nicks to be equal")
+	}
+	if CaseEqual("ascii", "nick[away]", "NICK{AWAY}") {
+		t.Error("expected ascii nicks to differ")
+	}
+}
+
+func TestReplyFold(t *testing.T) {
+	tt := []*irc.Message{
+		irc.ParseMessage(fmt.Sprintf("USER %s +iw * %s", nick, nick)),
+		irc.ParseMessage(fmt.Sprintf("NICK %s", nick)),
+		irc.ParseMessage("PRIVMSG other :hello"),
+	}
+	msg := ParseMessage(irc.ParseMessage(":other!other@example.com PRIVMSG UgJka :hi"))
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.Start()
+	go bot.Reply(msg, "hello")
+	for _, tc := range tt {
+		msg, err := srv.decode()
+		if err != nil {
+			t.Errorf("decoding a message failed: %v", err)
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(tc, msg) {
+			t.Errorf("expected %v, got %v", tc, msg)
+		}
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,181 @@
+package dumbirc
+
+import (
+	"strings"
+	"sync"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// DISCONNECTED is emitted once per connection when it is lost,
+// see Message.DisconnectReason
+const DISCONNECTED = "DISCONNECTED"
+
+// DisconnectReason tells why the connection was lost
+type DisconnectReason int
+
+// Disconnect reasons
+const (
+	//Connection error or the server closed the link without telling why
+	DisconnectUnknown DisconnectReason = iota
+	//The server or we stopped hearing from the other side
+	DisconnectPingTimeout
+	//K-line, G-line or similar ban
+	DisconnectBanned
+	//Killed for flooding or exceeding the SendQ
+	DisconnectExcessFlood
+	//The server is shutting down or restarting
+	DisconnectShutdown
+	//We asked to quit, with QUIT or Disconnect()
+	DisconnectQuit
+	//The server refused our registration, see RegistrationError
+	DisconnectRegistration
+	//Writing to the server failed or got stuck
+	DisconnectWriteError
+)
+
+var disconnectReasons = [...]string{
+	DisconnectUnknown:      "unknown",
+	DisconnectPingTimeout:  "ping timeout",
+	DisconnectBanned:       "banned",
+	DisconnectExcessFlood:  "excess flood",
+	DisconnectShutdown:     "server shutdown",
+	DisconnectQuit:         "quit",
+	DisconnectRegistration: "registration failed",
+	DisconnectWriteError:   "write error",
+}
+
+func (r DisconnectReason) String() string {
+	if r < 0 || int(r) >= len(disconnectReasons) {
+		return "unknown"
+	}
+	return disconnectReasons[r]
+}
+
+// DisconnectError is sent to Errchan when the connection is lost
+type DisconnectError struct {
+	Reason DisconnectReason
+	//Text of the server's ERROR, empty if there was none
+	Message string
+	//The read or write error that ended the connection
+	Err error
+}
+
+func (e *DisconnectError) Error() string {
+	s := "disconnected: " + e.Reason.String()
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *DisconnectError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether reconnecting makes sense, bans will not go away
+func (e *DisconnectError) Temporary() bool {
+	return e.Reason != DisconnectBanned
+}
+
+// ClassifyError classifies the text of an ERROR message
+func ClassifyError(text string) DisconnectReason {
+	lower := strings.ToLower(text)
+	has := func(v ...string) bool {
+		for _, s := range v {
+			if strings.Contains(lower, s) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("excess flood", "sendq", "flooding"):
+		return DisconnectExcessFlood
+	case has("ping timeout", "registration timeout"):
+		return DisconnectPingTimeout
+	case isBan(text):
+		return DisconnectBanned
+	case has("shutdown", "shutting down", "restart", "terminating"):
+		return DisconnectShutdown
+	case has("quit"):
+		return DisconnectQuit
+	}
+	return DisconnectUnknown
+}
+
+// disconnectState remembers why the connection is going away
+type disconnectState struct {
+	mu      sync.Mutex
+	reason  DisconnectReason
+	message string
+	//a reason is known, the first one wins
+	set bool
+	//DISCONNECTED was emitted for this connection
+	done bool
+}
+
+func (d *disconnectState) reset() {
+	d.mu.Lock()
+	d.reason, d.message, d.set, d.done = DisconnectUnknown, "", false, false
+	d.mu.Unlock()
+}
+
+func (d *disconnectState) setReason(reason DisconnectReason, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.set {
+		d.reason, d.message, d.set = reason, message, true
+	}
+}
+
+// DisconnectReason returns the reason of a DISCONNECTED event
+func (m *Message) DisconnectReason() DisconnectReason {
+	if m.Command != DISCONNECTED || len(m.Params) == 0 {
+		return DisconnectUnknown
+	}
+	for i, v := range disconnectReasons {
+		if v == m.Params[0] {
+			return DisconnectReason(i)
+		}
+	}
+	return DisconnectUnknown
+}
+
+// handleError records the reason the server gives in ERROR
+func (c *Connection) handleError() {
+	c.addHandler(irc.ERROR, func(m *Message) {
+		c.disc.setReason(ClassifyError(m.Trailing()), m.Trailing())
+	})
+}
+
+// lost ends the connection after a read or write error, reports why
+// on Errchan and emits DISCONNECTED, only the first call does anything
+func (c *Connection) lost(err error) {
+	c.close()
+	c.disc.mu.Lock()
+	if c.disc.done {
+		c.disc.mu.Unlock()
+		return
+	}
+	c.disc.done = true
+	derr := &DisconnectError{Reason: c.disc.reason, Message: c.disc.message, Err: err}
+	c.disc.mu.Unlock()
+	var out error = derr
+	if regErr := c.reg.failed(); regErr != nil {
+		derr.Reason = DisconnectRegistration
+		out = regErr
+	}
+	msg := NewMessage()
+	msg.Command = DISCONNECTED
+	msg.Params = []string{derr.Reason.String(), derr.Message}
+	msg.Content = derr.Message
+	c.emit(DISCONNECTED, msg)
+	select {
+	case c.Errchan <- out:
+	default:
+	}
+}
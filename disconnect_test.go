@@ -0,0 +1,107 @@
+package dumbirc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ugjka/messenger"
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+func TestClassifyError(t *testing.T) {
+	for text, reason := range map[string]DisconnectReason{
+		"Closing Link: host (Ping timeout: 240 seconds)": DisconnectPingTimeout,
+		"Closing Link: host (K-Lined)":                   DisconnectBanned,
+		"Closing Link: host (G-Lined: spam)":             DisconnectBanned,
+		"Closing Link: host (Excess Flood)":              DisconnectExcessFlood,
+		"Closing Link: host (Max SendQ exceeded)":        DisconnectExcessFlood,
+		"Closing Link: host (Server shutdown)":           DisconnectShutdown,
+		"Closing Link: host (Quit: bye)":                 DisconnectQuit,
+		"Closing Link: host (Client Quit)":               DisconnectQuit,
+		"Closing Link: host (Connection reset by peer)":  DisconnectUnknown,
+	} {
+		if got := ClassifyError(text); got != reason {
+			t.Errorf("%s: expected %v, got %v", text, reason, got)
+		}
+	}
+}
+
+func TestDisconnectError(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	events := make(chan *Message, 1)
+	bot.AddCallback(DISCONNECTED, func(m *Message) {
+		events <- m
+	})
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	srv.encode(fmt.Sprintf(":example.com 001 %s :Welcome", nick))
+	srv.encode("ERROR :Closing Link: host (Excess Flood)")
+	srv.stop()
+	var err error
+	select {
+	case err = <-bot.Errchan:
+	case <-time.After(time.Second):
+		t.Fatal("no error")
+	}
+	var de *DisconnectError
+	if !errors.As(err, &de) || de.Reason != DisconnectExcessFlood ||
+		de.Message != "Closing Link: host (Excess Flood)" || !errors.Is(err, io.EOF) {
+		t.Errorf("expected excess flood, got %v", err)
+	}
+	if m := <-events; m.DisconnectReason() != DisconnectExcessFlood {
+		t.Errorf("expected excess flood event, got %v", m.Params)
+	}
+	Destroy(bot)
+}
+
+func TestDisconnectQuit(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	bot.Disconnect()
+	var de *DisconnectError
+	if err := <-bot.Errchan; !errors.As(err, &de) || de.Reason != DisconnectQuit || !de.Temporary() {
+		t.Errorf("expected quit, got %v", err)
+	}
+	Destroy(bot)
+	srv.stop()
+}
+
+func TestDisconnectWriteError(t *testing.T) {
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.ConnTimeout = 50 * time.Millisecond
+	// nobody reads the other end, the write gets stuck
+	local, remote := net.Pipe()
+	defer remote.Close()
+	bot.conn = irc.NewConn(local)
+	bot.connected = true
+	bot.disconnect = make(chan struct{})
+	bot.messenger = messenger.New(5, false)
+	bot.Add(1)
+	go writeLoop(bot)
+	bot.Cmd("LUSERS")
+	var de *DisconnectError
+	select {
+	case err := <-bot.Errchan:
+		if !errors.As(err, &de) || de.Reason != DisconnectWriteError || !de.Temporary() {
+			t.Errorf("expected a write error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the stuck write was not noticed")
+	}
+	bot.Wait()
+	Destroy(bot)
+}
@@ -1,11 +1,13 @@
 package dumbirc
 
 import (
+	"bufio"
 	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -30,8 +32,24 @@ const (
 	JOIN      = irc.JOIN
 	KICK      = irc.KICK
 	NOTICE    = irc.NOTICE
+	ISUPPORT  = irc.RPL_ISUPPORT
+	ENDOFMOTD = irc.RPL_ENDOFMOTD
+	NOMOTD    = irc.ERR_NOMOTD
+	CHGHOST   = "CHGHOST"
+	SETNAME   = "SETNAME"
+	TAGMSG    = "TAGMSG"
+	ACCOUNT   = "ACCOUNT"
+	//Sent when the server applies a vhost/cloak
+	HOSTHIDDEN = RPL_HOSTHIDDEN
 	//Useful if you wanna check for activity
 	ANYMESSAGE = "ANY"
+	//Capability changes after registration (cap-notify)
+	CAPNEW = "CAP_NEW"
+	CAPDEL = "CAP_DEL"
+	//Our nick was changed, by us, services or the server on welcome
+	NICKCHANGED = "NICK_CHANGED"
+	//Someone is typing, see Message.TypingState
+	TYPING = "TYPING"
 )
 
 //Connection Settings
@@ -44,10 +62,20 @@ type Connection struct {
 	Password    string
 	Throttle    time.Duration
 	ConnTimeout time.Duration
+	//Disconnect when a PING is not answered in time, 0 disables
+	LagTimeout time.Duration
+	//Most lines waiting to be sent, 0 is no limit
+	MaxQueue int
+	//Ask for the modes and the users (WHO) of every channel we join
+	SyncChannels bool
+	//Capabilities to request when the server offers them
+	Caps []string
 	//Fake Connected status
 	DebugFakeConn bool
 	conn          *irc.Conn
+	reader        *bufio.Reader
 	callbacks     map[string][]func(*Message)
+	handlers      map[string][]func(*Message)
 	triggers      []Trigger
 	Log           *log.Logger
 	Debug         *log.Logger
@@ -64,6 +92,26 @@ type Connection struct {
 	connectedMu   sync.Mutex
 	testing       bool
 	testchan      chan struct{}
+	caps          *capState
+	isupport      *ISupport
+	isupportMu    sync.Mutex
+	typing        typingState
+	state         *channelState
+	whox          whoxState
+	whois         whoisState
+	listMu        sync.Mutex
+	fences        uint32
+	server        serverInfo
+	reg           registration
+	disc          disconnectState
+	lag           lagState
+	queue         *outQueue
+	flood         *FloodControl
+	floodMu       sync.Mutex
+	floodState    floodState
+	groups        uint32
+	blocks        uint32
+	outbox        outboxState
 	sync.WaitGroup
 }
 
@@ -76,8 +124,11 @@ func New(nick, user, server string, tls bool) *Connection {
 		TLS:         tls,
 		Throttle:    time.Millisecond * 500,
 		ConnTimeout: time.Second * 300,
+		LagTimeout:  time.Minute * 2,
+		queue:       newOutQueue(),
 		conn:        &irc.Conn{},
 		callbacks:   make(map[string][]func(*Message)),
+		handlers:    make(map[string][]func(*Message)),
 		triggers:    make([]Trigger, 0),
 		Log:         log.New(&devNull{}, "", log.Ldate|log.Ltime),
 		Debug:       log.New(&devNull{}, "debug", log.Ltime),
@@ -91,8 +142,28 @@ func New(nick, user, server string, tls bool) *Connection {
 		connected:   false,
 		connectedMu: sync.Mutex{},
 		testchan:    make(chan struct{}),
+		caps:        newCapState(),
+		isupport:    newISupport(),
+		typing:      typingState{last: make(map[string]time.Time)},
+		state:       newChannelState(),
+		whox:        whoxState{tokens: make(map[string]string)},
 	}
 	conn.getPrefix()
+	conn.trackPrefix()
+	conn.trackNick()
+	conn.handleCaps()
+	conn.handleISupport()
+	conn.handleTyping()
+	conn.trackChannels()
+	conn.trackUsers()
+	conn.trackServerInfo()
+	conn.trackRegistration()
+	conn.handleError()
+	conn.handleLag()
+	conn.handleFlood()
+	conn.dropChannelOutput()
+	conn.handleOutbox()
+	conn.lag.reset()
 	conn.prefix.Name = nick
 	return conn
 }
@@ -111,6 +182,29 @@ func (c *Connection) prefixSet(args []string) {
 	c.prefix.Host = args[2]
 }
 
+// prefixSetHost updates our user and host, empty values are left as is
+func (c *Connection) prefixSetHost(user, host string) {
+	c.prefixMu.Lock()
+	defer c.prefixMu.Unlock()
+	if user != "" {
+		c.prefix.User = user
+	}
+	if host != "" {
+		c.prefix.Host = host
+	}
+}
+
+func (c *Connection) prefixName() string {
+	c.prefixMu.Lock()
+	defer c.prefixMu.Unlock()
+	return c.prefix.Name
+}
+
+// fromMe reports whether the message was sent by us
+func (c *Connection) fromMe(m *Message) bool {
+	return m.Prefix != nil && c.EqualFold(m.Name, c.prefixName())
+}
+
 func (c *Connection) prefixlenGet() int {
 	c.prefixMu.Lock()
 	defer c.prefixMu.Unlock()
@@ -123,6 +217,15 @@ type Message struct {
 	Content   string
 	TimeStamp time.Time
 	To        string
+	//IRCv3 message tags, nil when the message had none
+	Tags map[string]string
+	//Sender is a bot, from the bot tag or WHO flags
+	Bot bool
+}
+
+func (m *Message) hasTag(name string) bool {
+	_, ok := m.Tags[name]
+	return ok
 }
 
 //ParseMessage converts irc.Message to Message
@@ -223,6 +326,25 @@ func (c *Connection) AddCallback(event string, callback func(*Message)) {
 	c.callbacks[event] = append(c.callbacks[event], callback)
 }
 
+// addHandler adds an internal handler, handlers run synchronously in the
+// read loop before any callbacks or triggers so they see messages in order
+func (c *Connection) addHandler(event string, handler func(*Message)) {
+	c.handlers[event] = append(c.handlers[event], handler)
+}
+
+func (c *Connection) runHandlers(m *Message) {
+	for _, v := range c.handlers[m.Command] {
+		v(m)
+	}
+}
+
+// emit runs the callbacks of a library generated event
+func (c *Connection) emit(event string, m *Message) {
+	for _, v := range c.callbacks[event] {
+		go v(m)
+	}
+}
+
 //Trigger scheme
 type Trigger struct {
 	Condition func(*Message) bool
@@ -257,21 +379,15 @@ func (c *Connection) RunCallbacks(m *Message) {
 	}
 }
 
+// send queues a line, the priority is picked by its command
 func (c *Connection) send(msg string) {
-	if !c.IsConnected() { // is this unnecesarry?
-		return
-	}
-	select {
-	case c.Send <- msg:
-	case <-c.disconnect:
-	}
+	c.sendPriority(priorityOf(msg), msg)
 }
 
-//Join channels
+//Join channels, see JoinContext to get errors
 func (c *Connection) Join(channels []string) {
-	for _, v := range channels {
-		c.send(irc.JOIN + " " + v)
-	}
+	lines, err := c.joinLines(channels)
+	c.sendLogged(PriorityNormal, 0, lines, err)
 }
 
 // ChMode is used to change users modes in a channel
@@ -283,8 +399,8 @@ func (c *Connection) ChMode(user, channel, mode string) {
 
 // Topic sets the channel 'channel' topic (requires bot has proper permissions)
 func (c *Connection) Topic(channel, topic string) {
-	msg := fmt.Sprintf("TOPIC %s :%s", channel, topic)
-	c.send(msg)
+	lines, err := c.topicLines(channel, topic)
+	c.sendLogged(PriorityNormal, 0, lines, err)
 }
 
 // Action sends an action to 'dest' (user or channel)
@@ -293,15 +409,24 @@ func (c *Connection) Action(dest, msg string) {
 	c.Msg(dest, msg)
 }
 
-// Notice sends a NOTICE message to 'dest' (user or channel)
+// Notice sends a NOTICE message to 'dest' (user or channel),
+// see NoticeContext to get errors
 func (c *Connection) Notice(dest, msg string) {
+	lines, err := c.msgLines(irc.NOTICE, dest, msg)
+	c.sendLogged(PriorityNormal, 0, lines, err)
+}
+
+// split splits msg into lines that fit the server's line length
+// once our prefix is prepended to them by the server
+func (c *Connection) split(command, dest, msg string) (lines []string) {
 	msg = replacer.Replace(msg)
-	prefLen := 2 + c.prefixlenGet() + len("NOTICE "+dest+" :")
-	for prefLen+len(msg) > 510 {
-		c.send("NOTICE " + dest + " :" + msg[:510-prefLen])
-		msg = msg[510-prefLen:]
+	max := c.ISupport().LineLen - 2
+	prefLen := 2 + c.prefixlenGet() + len(command+" "+dest+" :")
+	for max > prefLen && prefLen+len(msg) > max {
+		lines = append(lines, command+" "+dest+" :"+msg[:max-prefLen])
+		msg = msg[max-prefLen:]
 	}
-	c.send("NOTICE " + dest + " :" + msg)
+	return append(lines, command+" "+dest+" :"+msg)
 }
 
 //Pong sends pong
@@ -311,41 +436,46 @@ func (c *Connection) Pong() {
 
 //Ping sends ping
 func (c *Connection) Ping() {
-	c.send(irc.PING + " " + c.Server)
+	c.sendPriority(PriorityControl, irc.PING+" "+c.pingToken())
 }
 
-//Cmd sends command
+//Cmd sends command, see CmdContext to get errors
 func (c *Connection) Cmd(command string) {
-	c.send(command)
+	c.sendLogged(priorityOf(command), 0, []string{command}, nil)
 }
 
-//Msg sends privmessage
+//Msg sends privmessage, see MsgContext to get errors
 func (c *Connection) Msg(dest, msg string) {
-	msg = replacer.Replace(msg)
-	prefLen := 2 + c.prefixlenGet() + len(irc.PRIVMSG+" "+dest+" :")
-	for prefLen+len(msg) > 510 {
-		c.send(irc.PRIVMSG + " " + dest + " :" + msg[:510-prefLen])
-		msg = msg[510-prefLen:]
-	}
-	c.send(irc.PRIVMSG + " " + dest + " :" + msg)
+	lines, err := c.msgLines(irc.PRIVMSG, dest, msg)
+	c.sendLogged(PriorityNormal, 0, lines, err)
 }
 
-//MsgBulk sends message to many
+//MsgBulk sends message to many, it yields to all other messages
 func (c *Connection) MsgBulk(dest []string, msg string) {
 	for _, k := range dest {
-		c.Msg(k, msg)
+		c.MsgPriority(PriorityBulk, k, msg)
 	}
 }
 
 //NewNick Changes nick
 func (c *Connection) NewNick(nick string) {
 	c.send(irc.NICK + " " + nick)
-	c.prefixSet([]string{nick, "", ""})
+}
+
+// CurrentNick returns the nick the server knows us by,
+// c.Nick is the nick we want to have
+func (c *Connection) CurrentNick() string {
+	return c.prefixName()
+}
+
+// SetRealname changes the realname (gecos), requires the server to support SETNAME
+func (c *Connection) SetRealname(realname string) {
+	c.send(SETNAME + " :" + realname)
 }
 
 //Reply replies to a message
 func (c *Connection) Reply(m *Message, reply string) {
-	if m.To == c.Nick {
+	if c.EqualFold(m.To, c.CurrentNick()) {
 		c.Msg(m.Name, reply)
 	} else {
 		c.Msg(m.To, reply)
@@ -354,6 +484,13 @@ func (c *Connection) Reply(m *Message, reply string) {
 
 //Disconnect disconnects from irc
 func (c *Connection) Disconnect() {
+	if c.IsConnected() {
+		c.disc.setReason(DisconnectQuit, "")
+	}
+	c.close()
+}
+
+func (c *Connection) close() {
 	c.connectedMu.Lock()
 	defer c.connectedMu.Unlock()
 	if !c.connected {
@@ -365,14 +502,19 @@ func (c *Connection) Disconnect() {
 	close(c.disconnect)
 }
 
-func changeNick(nick string) string {
-	if len(nick) < 16 {
+// changeNick appends an underscore, making room for it when at the max nick length
+func changeNick(nick string, max int) string {
+	if len(nick) < max {
 		nick += "_"
 		return nick
 	}
 	nick = strings.TrimRight(nick, "_")
-	if len(nick) > 12 {
-		nick = nick[:12] + "_"
+	keep := max - 4
+	if keep < 1 {
+		keep = 1
+	}
+	if len(nick) > keep {
+		nick = nick[:keep] + "_"
 	}
 	return nick
 }
@@ -386,6 +528,9 @@ func (c *Connection) LogNotices() {
 
 //HandleNickTaken changes nick when nick taken
 func (c *Connection) HandleNickTaken() {
+	c.reg.mu.Lock()
+	c.reg.nickTaken = true
+	c.reg.mu.Unlock()
 	c.AddCallback(NICKTAKEN, func(msg *Message) {
 		if c.Password != "" {
 			rand.Seed(time.Now().UnixNano())
@@ -393,8 +538,8 @@ func (c *Connection) HandleNickTaken() {
 			for i := 0; i < 4; i++ {
 				tmp += fmt.Sprintf("%d", rand.Intn(9))
 			}
-			if len(c.Nick) > 12 {
-				c.NewNick(c.Nick[:12] + tmp)
+			if max := c.ISupport().NickLen - len(tmp); max > 0 && len(c.Nick) > max {
+				c.NewNick(c.Nick[:max] + tmp)
 			} else {
 				c.NewNick(c.Nick + tmp)
 			}
@@ -433,9 +578,13 @@ func (c *Connection) HandleNickTaken() {
 			}
 			return
 		}
-		c.Log.Printf("nick %s taken, changing nick", c.Nick)
-		c.Nick = changeNick(c.Nick)
-		c.NewNick(c.Nick)
+		// "433 * nick :Nickname is already in use."
+		taken := c.CurrentNick()
+		if len(msg.Params) > 2 {
+			taken = msg.Params[1]
+		}
+		c.Log.Printf("nick %s taken, changing nick", taken)
+		c.NewNick(changeNick(taken, c.ISupport().NickLen))
 	})
 }
 
@@ -467,7 +616,7 @@ func (c *Connection) HandlePingPong() {
 				tick.Stop()
 				return
 			default:
-				c.Log.Println("got no pong")
+				c.Log.Printf("got no pong, lag %v", c.Lag())
 			}
 		}
 	}(pingTick)
@@ -504,16 +653,87 @@ func (c *Connection) sendTestBeakon() {
 	c.testchan <- struct{}{}
 }
 
+// getPrefix takes our user and host from our own JOINs,
+// the nick is left to trackNick
 func (c *Connection) getPrefix() {
-	c.AddTrigger(Trigger{
-		Condition: func(m *Message) bool {
-			return m.Command == JOIN && m.Name == c.Nick
-		},
-		Response: func(m *Message) {
-			c.sendTestBeakon()
-			c.prefixSet([]string{m.Name, m.User, m.Host})
-			c.sendTestBeakon()
-		},
+	c.addHandler(JOIN, func(m *Message) {
+		if m.Prefix != nil && c.fromMe(m) {
+			c.prefixSetHost(m.User, m.Host)
+		}
+	})
+}
+
+// trackNick follows our current nick from the server
+func (c *Connection) trackNick() {
+	c.addHandler(WELCOME, func(m *Message) {
+		// the server may have truncated or changed the nick we asked for
+		if len(m.Params) == 0 {
+			return
+		}
+		c.setCurrentNick(m, m.Params[0])
+	})
+	c.addHandler(irc.NICK, func(m *Message) {
+		if len(m.Params) == 0 || !c.fromMe(m) {
+			return
+		}
+		c.setCurrentNick(m, m.Params[0])
+	})
+}
+
+func (c *Connection) setCurrentNick(m *Message, nick string) {
+	c.prefixMu.Lock()
+	old := c.prefix.Name
+	c.prefix.Name = nick
+	c.prefixMu.Unlock()
+	if old != nick {
+		c.emit(NICKCHANGED, m)
+	}
+}
+
+// welcomeMask extracts our full mask from 001 "Welcome ... nick!user@host"
+func (c *Connection) welcomeMask(m *Message) *irc.Prefix {
+	fields := strings.Fields(m.Trailing())
+	if len(fields) == 0 || len(m.Params) < 2 {
+		return nil
+	}
+	mask := irc.ParsePrefix(fields[len(fields)-1])
+	if !mask.IsHostmask() || !c.EqualFold(mask.Name, m.Params[0]) {
+		return nil
+	}
+	return mask
+}
+
+// trackPrefix keeps our own prefix up to date when the server changes it,
+// the nick is left to trackNick
+func (c *Connection) trackPrefix() {
+	c.addHandler(WELCOME, func(m *Message) {
+		if mask := c.welcomeMask(m); mask != nil {
+			c.prefixSetHost(mask.User, mask.Host)
+		}
+	})
+	c.addHandler(CHGHOST, func(m *Message) {
+		if len(m.Params) > 1 && c.fromMe(m) {
+			c.prefixSetHost(m.Params[0], m.Params[1])
+		}
+	})
+	c.addHandler(HOSTHIDDEN, func(m *Message) {
+		if len(m.Params) < 3 {
+			return
+		}
+		// the host may come as "user@host"
+		host := m.Params[1]
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			c.prefixSetHost(host[:i], host[i+1:])
+			return
+		}
+		c.prefixSetHost("", host)
+	})
+	c.addHandler(SETNAME, func(m *Message) {
+		if c.fromMe(m) {
+			c.prefixMu.Lock()
+			c.RealN = m.Trailing()
+			c.prefixMu.Unlock()
+		}
 	})
 }
 
@@ -534,9 +754,21 @@ func (c *Connection) Start() {
 	c.connected = true
 	c.messenger = messenger.New(5, false)
 	c.connectedMu.Unlock()
+	c.caps.reset()
+	c.state.reset()
+	c.server.reset()
+	c.reg.reset()
+	c.disc.reset()
+	c.lag.reset()
+	c.queue.reset()
+	c.floodState.reset(c.floodControl())
+	c.prefixSet([]string{c.Nick, "", ""})
+	c.isupportMu.Lock()
+	c.isupport = newISupport()
+	c.isupportMu.Unlock()
 	err = identify(c)
 	if err != nil {
-		c.Disconnect()
+		c.close()
 		c.Errchan <- err
 		return
 	}
@@ -546,23 +778,50 @@ func (c *Connection) Start() {
 }
 
 func dial(c *Connection) (err error) {
+	var conn net.Conn
 	if c.TLS {
-		tls, err := tls.Dial("tcp", c.Server, &tls.Config{})
-		if err != nil {
-			return err
-		}
-		c.conn = irc.NewConn(tls)
+		conn, err = tls.Dial("tcp", c.Server, &tls.Config{})
 	} else {
-		var err error
-		c.conn, err = irc.Dial(c.Server)
+		conn, err = net.Dial("tcp", c.Server)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn = irc.NewConn(conn)
+	// we read lines ourselves, the irc decoder does not know about message tags
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// decode reads the next message, skipping empty lines
+func (c *Connection) decode() (*Message, error) {
+	for {
+		line, err := c.reader.ReadString('\n')
 		if err != nil {
-			return err
+			return nil, err
 		}
+		tags, rest := splitTags(line)
+		raw := irc.ParseMessage(rest)
+		if raw == nil {
+			continue
+		}
+		c.Debug.Printf("← %s", strings.TrimRight(line, "\r\n"))
+		msg := ParseMessage(raw)
+		msg.Tags = tags
+		return msg, nil
 	}
-	return nil
 }
 
 func identify(c *Connection) (err error) {
+	if len(c.Caps) > 0 {
+		out := "CAP LS 302"
+		c.Debug.Printf("→ %s", out)
+		_, err := io.WriteString(c.conn, out)
+		if err != nil {
+			return err
+		}
+		c.caps.setNegotiating(true)
+	}
 	if c.Password != "" {
 		out := "PASS " + c.Password
 		c.Debug.Printf("→ %s", out)
@@ -592,48 +851,79 @@ func identify(c *Connection) (err error) {
 func readLoop(c *Connection) {
 	defer c.Done()
 	for {
-		timeout := time.AfterFunc(c.ConnTimeout, func() { c.conn.Close() })
-		raw, err := c.conn.Decode()
+		timeout := time.AfterFunc(c.ConnTimeout, c.timedOut)
+		msg, err := c.decode()
 		if err != nil {
 			timeout.Stop()
-			c.Disconnect()
-			select {
-			case c.Errchan <- err:
-			default:
-			}
+			c.lost(err)
 			return
 		}
 		timeout.Stop()
-		c.Debug.Printf("← %s", raw)
-		msg := ParseMessage(raw)
+		msg.Bot = msg.hasTag("bot") || c.isKnownBot(msg)
+		c.runHandlers(msg)
 		c.RunCallbacks(msg)
 		c.RunTriggers(msg)
 		c.messenger.Broadcast(msg)
 	}
 }
 
+// timedOut closes a connection that went quiet for ConnTimeout
+func (c *Connection) timedOut() {
+	c.disc.setReason(DisconnectPingTimeout, "")
+	c.conn.Close()
+}
+
+// writeTimedOut closes a connection a write is stuck on for ConnTimeout
+func (c *Connection) writeTimedOut() {
+	c.disc.setReason(DisconnectWriteError, "")
+	c.conn.Close()
+}
+
 func writeLoop(c *Connection) {
 	defer c.Done()
 	for {
-		var v string
-		select {
-		case <-c.disconnect:
-			return
-		case v = <-c.Send:
+		line, p, ok := c.queue.peek()
+		if !ok {
+			select {
+			case <-c.disconnect:
+				return
+			case v := <-c.Send:
+				c.sendPriority(PriorityNormal, v)
+			case <-c.queue.wake:
+			}
+			continue
+		}
+		// control lines are never held back
+		flood := c.floodControl()
+		if wait := c.floodState.delay(flood, c.Throttle, line); p < PriorityControl && wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-c.disconnect:
+				timer.Stop()
+				return
+			case v := <-c.Send:
+				c.sendPriority(PriorityNormal, v)
+			case <-c.queue.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
 		}
+		out, _ := c.queue.pop()
+		v := out.line
 		c.Debug.Printf("→ %s", v)
-		timeout := time.AfterFunc(c.ConnTimeout, func() { c.conn.Close() })
+		timeout := time.AfterFunc(c.ConnTimeout, c.writeTimedOut)
 		_, err := io.WriteString(c.conn, v)
 		if err != nil {
 			timeout.Stop()
-			c.Disconnect()
-			select {
-			case c.Errchan <- err:
-			default:
-			}
+			c.disc.setReason(DisconnectWriteError, "")
+			c.lost(err)
 			return
 		}
 		timeout.Stop()
-		time.Sleep(c.Throttle)
+		c.floodState.sent(flood, v)
+		if out.last {
+			close(out.wait.done)
+		}
 	}
 }
@@ -1,7 +1,9 @@
 package dumbirc
 
 import (
+	"bufio"
 	"net"
+	"strings"
 
 	irc "gopkg.in/sorcix/irc.v2"
 )
@@ -9,7 +11,7 @@ import (
 const SERVER = "127.0.0.1:54321"
 
 type ircServer struct {
-	dec       *irc.Decoder
+	dec       *bufio.Reader
 	enc       *irc.Encoder
 	listener  net.Listener
 	conn      net.Conn
@@ -30,7 +32,7 @@ func newServer() *ircServer {
 	s := &ircServer{
 		connReady: make(chan struct{}),
 	}
-	s.dec = irc.NewDecoder(s)
+	s.dec = bufio.NewReader(s)
 	s.enc = irc.NewEncoder(s)
 	s.startListener()
 	go s.monitor()
@@ -65,5 +67,21 @@ func (i *ircServer) encode(msg string) (err error) {
 }
 
 func (i *ircServer) decode() (msg *irc.Message, err error) {
-	return i.dec.Decode()
+	line, err := i.dec.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return irc.ParseMessage(line), nil
+}
+
+// readLine returns the next line without parsing it, for lines with message tags
+func (i *ircServer) readLine() (line string, err error) {
+	line, err = i.dec.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// raw writes the line as is, used for lines the irc encoder can't produce
+func (i *ircServer) raw(line string) (err error) {
+	_, err = i.Write([]byte(line + "\r\n"))
+	return err
 }
@@ -110,7 +110,7 @@ func TestHandlePingPong(t *testing.T) {
 	tt := []*irc.Message{
 		irc.ParseMessage(fmt.Sprintf("USER %s +iw * %s", nick, nick)),
 		irc.ParseMessage(fmt.Sprintf("NICK %s", nick)),
-		irc.ParseMessage(fmt.Sprintf("PING %s", SERVER)),
+		irc.ParseMessage("PING lag1"),
 	}
 	srv := newServer()
 	bot := New(nick, nick, SERVER, false)
@@ -173,11 +173,11 @@ func TestGetPrefix(t *testing.T) {
 	srv := newServer()
 	bot := New(nick, nick, SERVER, false)
 	bot.SetThrottle(0)
-	bot.testing = true
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
 	bot.Start()
-	srv.encode(join)
-	<-bot.testchan
-	srv.encode(fmt.Sprintf(":example.com 001 %s :Welcome Internet Relay Chat Network", nick))
 	for _, tc := range tt {
 		msg, err := srv.decode()
 		if err != nil {
@@ -188,8 +188,10 @@ func TestGetPrefix(t *testing.T) {
 			t.Errorf("expected %v, got %v", tc, msg)
 		}
 	}
+	srv.encode(fmt.Sprintf(":example.com 001 %s :Welcome Internet Relay Chat Network", nick))
+	srv.encode(join)
+	syncBot(srv, done)
 	m := irc.ParseMessage(join)
-	<-bot.testchan
 	prflen := bot.prefixlenGet()
 	if m.Prefix.Len() != prflen {
 		t.Errorf("expected prefix lenght of %d, got %d", m.Prefix.Len(), prflen)
@@ -307,11 +309,12 @@ func TestMsg(t *testing.T) {
 	bot := New(nick, nick, SERVER, false)
 	bot.SetThrottle(0)
 	bot.Start()
-	go func(bot *Connection) {
-		bot.MsgBulk(chans, "hello")
-		bot.Msg("#test", longmsg)
-	}(bot)
-	for _, tc := range tt {
+	go bot.MsgBulk(chans, "hello")
+	for i, tc := range tt {
+		if i == 4 {
+			// after the bulk messages, they would yield to it
+			bot.Msg("#test", longmsg)
+		}
 		msg, err := srv.decode()
 		if err != nil {
 			t.Errorf("decoding a message failed: %v", err)
@@ -332,11 +335,12 @@ func TestReply(t *testing.T) {
 		NewMessage(),
 	}
 	msgs[0].To = nick
+	msgs[0].Name = "someone"
 	msgs[1].To = "#test"
 	tt := []*irc.Message{
 		irc.ParseMessage(fmt.Sprintf("USER %s +iw * %s", nick, nick)),
 		irc.ParseMessage(fmt.Sprintf("NICK %s", nick)),
-		irc.ParseMessage(fmt.Sprintf("PRIVMSG :hello")),
+		irc.ParseMessage(fmt.Sprintf("PRIVMSG someone :hello")),
 		irc.ParseMessage(fmt.Sprintf("PRIVMSG %s :hello", "#test")),
 	}
 	srv := newServer()
@@ -395,3 +399,104 @@ func TestNotice(t *testing.T) {
 	Destroy(bot)
 	srv.stop()
 }
+
+func TestWelcomeMask(t *testing.T) {
+	tt := []struct {
+		line string
+		mask string
+	}{
+		{fmt.Sprintf(":example.com 001 %s :Welcome to the Network %s!bot@example.org", nick, nick), nick + "!bot@example.org"},
+		{fmt.Sprintf(":example.com 001 %s :Welcome to the Network %s", nick, nick), ""},
+		{fmt.Sprintf(":example.com 001 %s :Welcome to the Network other!bot@example.org", nick), ""},
+		{fmt.Sprintf(":example.com 001 %s :Welcome to the Network %s!bot@example.org", nick, strings.ToUpper(nick)),
+			strings.ToUpper(nick) + "!bot@example.org"},
+	}
+	bot := New(nick, nick, SERVER, false)
+	defer Destroy(bot)
+	for _, tc := range tt {
+		mask := bot.welcomeMask(ParseMessage(irc.ParseMessage(tc.line)))
+		got := ""
+		if mask != nil {
+			got = mask.String()
+		}
+		if got != tc.mask {
+			t.Errorf("expected mask %q, got %q", tc.mask, got)
+		}
+	}
+}
+
+func TestTrackPrefix(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	ownPrefix := func() string {
+		bot.prefixMu.Lock()
+		defer bot.prefixMu.Unlock()
+		return bot.prefix.String()
+	}
+	bot.Start()
+	srv.encode(fmt.Sprintf(":example.com 001 %s :Welcome to the Network %s!bot@example.org", nick, nick))
+	// e.g. services renaming us right away
+	srv.encode(fmt.Sprintf(":%s!bot@example.org NICK Guest1", nick))
+	syncBot(srv, done)
+	if prefix := ownPrefix(); prefix != "Guest1!bot@example.org" {
+		t.Errorf("expected prefix %s, got %s", "Guest1!bot@example.org", prefix)
+	}
+	srv.encode(":Guest1!bot@example.com NICK " + nick)
+	srv.encode(fmt.Sprintf(":%s!%s@example.com CHGHOST ident new.host.example", nick, nick))
+	syncBot(srv, done)
+	if prefix := ownPrefix(); prefix != nick+"!ident@new.host.example" {
+		t.Errorf("expected prefix %s, got %s", nick+"!ident@new.host.example", prefix)
+	}
+	srv.encode(fmt.Sprintf(":example.com 396 %s cloak@hidden.example :is now your displayed host", nick))
+	syncBot(srv, done)
+	if prefix := ownPrefix(); prefix != nick+"!cloak@hidden.example" {
+		t.Errorf("expected prefix %s, got %s", nick+"!cloak@hidden.example", prefix)
+	}
+	srv.encode(fmt.Sprintf(":%s!cloak@hidden.example SETNAME :new realname", nick))
+	syncBot(srv, done)
+	bot.prefixMu.Lock()
+	realname := bot.RealN
+	bot.prefixMu.Unlock()
+	if realname != "new realname" {
+		t.Errorf("expected realname %q, got %q", "new realname", realname)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
+
+func TestTrackNick(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	changed := make(chan *Message)
+	bot.AddCallback(NICKCHANGED, func(m *Message) {
+		changed <- m
+	})
+	bot.Start()
+	srv.encode(fmt.Sprintf(":example.com 001 %s_ :Welcome Internet Relay Chat Network", nick))
+	<-changed
+	if current := bot.CurrentNick(); current != nick+"_" {
+		t.Errorf("expected current nick %s_, got %s", nick, current)
+	}
+	srv.encode(fmt.Sprintf(":other!other@example.com NICK %s", nick))
+	srv.encode(fmt.Sprintf(":%s_!%s@example.com NICK renamed", nick, nick))
+	m := <-changed
+	if m.Name != nick+"_" || m.Params[0] != "renamed" {
+		t.Errorf("expected nick change from %s_ to renamed, got %v", nick, m)
+	}
+	if current := bot.CurrentNick(); current != "renamed" {
+		t.Errorf("expected current nick renamed, got %s", current)
+	}
+	if bot.Nick != nick {
+		t.Errorf("expected wanted nick to stay %s, got %s", nick, bot.Nick)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,125 @@
+package dumbirc
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors for ERR_* replies, a ReplyError matches them with errors.Is
+var (
+	ErrNoSuchServer      = errors.New("no such server")
+	ErrNoSuchChannel     = errors.New("no such channel")
+	ErrCannotSendToChan  = errors.New("cannot send to channel")
+	ErrTooManyChannels   = errors.New("joined too many channels")
+	ErrUnknownCommand    = errors.New("unknown command")
+	ErrErroneusNickname  = errors.New("erroneous nickname")
+	ErrNicknameInUse     = errors.New("nickname is already in use")
+	ErrUserNotInChannel  = errors.New("user is not on that channel")
+	ErrNotOnChannel      = errors.New("not on that channel")
+	ErrUserOnChannel     = errors.New("user is already on that channel")
+	ErrNeedMoreParams    = errors.New("not enough parameters")
+	ErrChannelIsFull     = errors.New("channel is full")
+	ErrUnknownMode       = errors.New("unknown mode")
+	ErrInviteOnlyChan    = errors.New("channel is invite only")
+	ErrBannedFromChan    = errors.New("banned from channel")
+	ErrBadChannelKey     = errors.New("bad channel key")
+	ErrBadChanMask       = errors.New("bad channel mask")
+	ErrNeedReggedNick    = errors.New("registered nick needed")
+	ErrNoPrivileges      = errors.New("no privileges")
+	ErrChanOPrivsNeeded  = errors.New("channel operator privileges needed")
+	ErrUsersDontMatch    = errors.New("cannot change mode for other users")
+	ErrUModeUnknownFlag  = errors.New("unknown user mode flag")
+	ErrInvalidModeParam  = errors.New("invalid mode parameter")
+	ErrBadChanName       = errors.New("illegal channel name")
+	ErrNicknameCollision = errors.New("nickname collision")
+)
+
+var replyErrors = map[string]error{
+	ERR_NOSUCHNICK:       ErrNoSuchNick,
+	ERR_NOSUCHSERVER:     ErrNoSuchServer,
+	ERR_NOSUCHCHANNEL:    ErrNoSuchChannel,
+	ERR_CANNOTSENDTOCHAN: ErrCannotSendToChan,
+	ERR_TOOMANYCHANNELS:  ErrTooManyChannels,
+	ERR_UNKNOWNCOMMAND:   ErrUnknownCommand,
+	ERR_NOMOTD:           ErrNoMOTD,
+	ERR_ERRONEUSNICKNAME: ErrErroneusNickname,
+	ERR_NICKNAMEINUSE:    ErrNicknameInUse,
+	ERR_NICKCOLLISION:    ErrNicknameCollision,
+	ERR_USERNOTINCHANNEL: ErrUserNotInChannel,
+	ERR_NOTONCHANNEL:     ErrNotOnChannel,
+	ERR_USERONCHANNEL:    ErrUserOnChannel,
+	ERR_NEEDMOREPARAMS:   ErrNeedMoreParams,
+	ERR_CHANNELISFULL:    ErrChannelIsFull,
+	ERR_UNKNOWNMODE:      ErrUnknownMode,
+	ERR_INVITEONLYCHAN:   ErrInviteOnlyChan,
+	ERR_BANNEDFROMCHAN:   ErrBannedFromChan,
+	ERR_BADCHANNELKEY:    ErrBadChannelKey,
+	ERR_BADCHANMASK:      ErrBadChanMask,
+	ERR_NEEDREGGEDNICK:   ErrNeedReggedNick,
+	ERR_BADCHANNAME:      ErrBadChanName,
+	ERR_NOPRIVILEGES:     ErrNoPrivileges,
+	ERR_CHANOPRIVSNEEDED: ErrChanOPrivsNeeded,
+	ERR_UMODEUNKNOWNFLAG: ErrUModeUnknownFlag,
+	ERR_USERSDONTMATCH:   ErrUsersDontMatch,
+	ERR_INVALIDMODEPARAM: ErrInvalidModeParam,
+}
+
+// ReplyError is an error reply from the server, use errors.As to get it
+// and errors.Is to compare it with the Err* values above
+type ReplyError struct {
+	//The numeric e.g. "403"
+	Code string
+	//Name of the numeric e.g. "ERR_NOSUCHCHANNEL", empty if unknown
+	Name string
+	//The nick, channel or command the error is about, may be empty
+	Target string
+	//Parameters between our nick and the text, e.g. nick and channel for 441
+	Params []string
+	//Human readable text from the server
+	Text string
+}
+
+func (e *ReplyError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = e.Code
+	}
+	if e.Target == "" {
+		return name + ": " + e.Text
+	}
+	return name + " " + e.Target + ": " + e.Text
+}
+
+// Is reports whether the reply is the given Err* value
+func (e *ReplyError) Is(target error) bool {
+	err, ok := replyErrors[e.Code]
+	return ok && err == target
+}
+
+// isErrorNumeric reports whether a numeric is an error reply
+func isErrorNumeric(code string) bool {
+	if len(code) != 3 || code[0] < '0' || code[0] > '9' {
+		return false
+	}
+	if name := NumericName(code); name != "" {
+		return strings.HasPrefix(name, "ERR_")
+	}
+	return code[0] == '4' || code[0] == '5'
+}
+
+// ErrorFromMessage returns a *ReplyError for an error numeric, nil otherwise
+func ErrorFromMessage(m *Message) error {
+	if m == nil || m.Message == nil || !isErrorNumeric(m.Command) {
+		return nil
+	}
+	e := &ReplyError{Code: m.Command, Name: NumericName(m.Command)}
+	// "ERR me target ... :text", the first param is our nick
+	if len(m.Params) > 0 {
+		e.Text = m.Params[len(m.Params)-1]
+	}
+	if len(m.Params) > 2 {
+		e.Params = append([]string(nil), m.Params[1:len(m.Params)-1]...)
+		e.Target = e.Params[0]
+	}
+	return e
+}
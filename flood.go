@@ -0,0 +1,166 @@
+package dumbirc
+
+import (
+	"sync"
+	"time"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// FloodControl is a token bucket limiting how fast lines are sent,
+// it replaces Throttle when set with SetFloodControl
+type FloodControl struct {
+	//Lines that can be sent at once
+	Burst int
+	//Time to regain one line
+	Refill time.Duration
+	//When > 0 a line costs one more line per this many bytes,
+	//like the penalties of some ircds
+	BytesPerToken int
+}
+
+// cost of a line in tokens, never more than the bucket holds
+func (f *FloodControl) cost(line string) float64 {
+	cost := 1
+	if f.BytesPerToken > 0 {
+		cost += len(line) / f.BytesPerToken
+	}
+	if cost > f.burst() {
+		cost = f.burst()
+	}
+	return float64(cost)
+}
+
+func (f *FloodControl) burst() int {
+	if f.Burst < 1 {
+		return 1
+	}
+	return f.Burst
+}
+
+const (
+	//at most 2^maxBackoff times slower
+	maxBackoff = 3
+	//one level of back off is forgotten after this long without flooding
+	backoffDecay = time.Minute
+)
+
+// floodState is the token bucket and the back off after being told we flood,
+// the back off survives reconnects so a flood kill is not repeated
+type floodState struct {
+	mu     sync.Mutex
+	tokens float64
+	//last refill or send
+	last    time.Time
+	level   int
+	flooded time.Time
+}
+
+// reset fills the bucket for a new connection
+func (f *floodState) reset(cfg *FloodControl) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens, f.last = 0, time.Time{}
+	if cfg != nil {
+		f.tokens = float64(cfg.burst())
+	}
+}
+
+// backoffLevel is the back off level left after decaying
+func (f *floodState) backoffLevel(now time.Time) int {
+	level := f.level - int(now.Sub(f.flooded)/backoffDecay)
+	if level < 0 {
+		return 0
+	}
+	return level
+}
+
+// factor slows sending down after flooding
+func (f *floodState) factor(now time.Time) time.Duration {
+	return time.Duration(1) << uint(f.backoffLevel(now))
+}
+
+// backOff slows us down one more level
+func (f *floodState) backOff() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	f.level = f.backoffLevel(now) + 1
+	if f.level > maxBackoff {
+		f.level = maxBackoff
+	}
+	f.flooded = now
+}
+
+// refill adds the tokens earned since the last call, the lock must be held
+func (f *floodState) refill(cfg *FloodControl, now time.Time) {
+	interval := cfg.Refill * f.factor(now)
+	if interval <= 0 {
+		f.tokens = float64(cfg.burst())
+	} else if !f.last.IsZero() {
+		f.tokens += float64(now.Sub(f.last)) / float64(interval)
+		if max := float64(cfg.burst()); f.tokens > max {
+			f.tokens = max
+		}
+	}
+	f.last = now
+}
+
+// delay returns how long line has to wait, throttle is used without a bucket
+func (f *floodState) delay(cfg *FloodControl, throttle time.Duration, line string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if cfg == nil {
+		return throttle*f.factor(now) - now.Sub(f.last)
+	}
+	f.refill(cfg, now)
+	if cost := cfg.cost(line); f.tokens < cost {
+		return time.Duration((cost - f.tokens) * float64(cfg.Refill*f.factor(now)))
+	}
+	return 0
+}
+
+// sent takes the tokens for a line that was written
+func (f *floodState) sent(cfg *FloodControl, line string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if cfg == nil {
+		f.last = now
+		return
+	}
+	f.refill(cfg, now)
+	f.tokens -= cfg.cost(line)
+}
+
+// SetFloodControl limits sending with a token bucket instead of Throttle,
+// nil goes back to Throttle. E.g. 5 lines at once, then one every 2 seconds
+//
+//	c.SetFloodControl(&FloodControl{Burst: 5, Refill: 2 * time.Second})
+func (c *Connection) SetFloodControl(f *FloodControl) {
+	c.floodMu.Lock()
+	c.flood = f
+	c.floodMu.Unlock()
+	c.floodState.reset(f)
+}
+
+func (c *Connection) floodControl() *FloodControl {
+	c.floodMu.Lock()
+	defer c.floodMu.Unlock()
+	return c.flood
+}
+
+// handleFlood backs off when the server says we are too fast
+func (c *Connection) handleFlood() {
+	c.addHandler(irc.RPL_TRYAGAIN, func(m *Message) {
+		c.Log.Println("server asked us to slow down")
+		c.floodState.backOff()
+	})
+	c.addHandler(irc.ERROR, func(m *Message) {
+		if ClassifyError(m.Trailing()) == DisconnectExcessFlood {
+			c.Log.Println("killed for flooding, slowing down")
+			c.floodState.backOff()
+		}
+	})
+}
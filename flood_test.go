@@ -0,0 +1,81 @@
+package dumbirc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFloodBucket(t *testing.T) {
+	cfg := &FloodControl{Burst: 3, Refill: time.Second, BytesPerToken: 100}
+	var f floodState
+	f.reset(cfg)
+	for i := 0; i < 3; i++ {
+		if d := f.delay(cfg, 0, "PRIVMSG #test :hi"); d != 0 {
+			t.Fatalf("line %d: expected no delay within the burst, got %v", i, d)
+		}
+		f.sent(cfg, "PRIVMSG #test :hi")
+	}
+	if d := f.delay(cfg, 0, "PRIVMSG #test :hi"); d < 900*time.Millisecond || d > time.Second {
+		t.Errorf("expected to wait for a refill, got %v", d)
+	}
+	f.backOff()
+	if d := f.delay(cfg, 0, "PRIVMSG #test :hi"); d < 1900*time.Millisecond || d > 2*time.Second {
+		t.Errorf("expected the back off to double the wait, got %v", d)
+	}
+	f = floodState{}
+	f.reset(cfg)
+	long := fmt.Sprintf("PRIVMSG #test :%0200d", 0)
+	if cost := cfg.cost(long); cost != 3 {
+		t.Errorf("expected a long line to cost 3, got %v", cost)
+	}
+	f.sent(cfg, long)
+	if d := f.delay(cfg, 0, "PRIVMSG #test :hi"); d < 900*time.Millisecond {
+		t.Errorf("expected the long line to empty the bucket, got %v", d)
+	}
+}
+
+func TestFloodThrottle(t *testing.T) {
+	var f floodState
+	if d := f.delay(nil, time.Second, "PING"); d > 0 {
+		t.Errorf("expected the first line to go at once, got %v", d)
+	}
+	f.sent(nil, "PING")
+	if d := f.delay(nil, time.Second, "PING"); d < 900*time.Millisecond {
+		t.Errorf("expected to wait for the throttle, got %v", d)
+	}
+	if d := f.delay(nil, 0, "PING"); d > 0 {
+		t.Errorf("expected no throttle, got %v", d)
+	}
+	f.flooded = time.Now().Add(-backoffDecay)
+	f.level = maxBackoff
+	if factor := f.factor(time.Now()); factor != 1<<(maxBackoff-1) {
+		t.Errorf("expected the back off to decay, got %v", factor)
+	}
+}
+
+func TestFloodBackOff(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	srv.encode(fmt.Sprintf(":example.com 263 %s LIST :Please wait a while and try again.", nick))
+	srv.encode(fmt.Sprintf(":example.com 263 %s LIST :Please wait a while and try again.", nick))
+	syncBot(srv, done)
+	bot.floodState.mu.Lock()
+	level := bot.floodState.level
+	bot.floodState.mu.Unlock()
+	if level != 2 {
+		t.Errorf("expected back off level 2, got %d", level)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,184 @@
+package dumbirc
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ugjka/dumbirc/modes"
+)
+
+// ISupport holds the server features advertised in RPL_ISUPPORT (005).
+// Tokens the server did not send keep the defaults the library assumes
+type ISupport struct {
+	Network     string
+	CaseMapping string
+	NickLen     int
+	//0 when the server sets no limit
+	ChannelLen int
+	TopicLen   int
+	//Maximum line length including CRLF
+	LineLen   int
+	ChanTypes string
+	//PREFIX, modes and their symbols in the same order, e.g. "ov" and "@+"
+	PrefixModes   string
+	PrefixSymbols string
+	//CHANMODES types A (lists), B (always a param), C (param when set), D (no param)
+	ChanModes [4]string
+	//Maximum modes with a parameter per MODE command, 0 is no limit
+	Modes int
+	//Maximum targets per command, 0 means no limit
+	TargMax   map[string]int
+	StatusMsg string
+	Bot       string
+	WhoX      bool
+	EList     string
+	//All tokens as received
+	Raw map[string]string
+}
+
+func newISupport() *ISupport {
+	return &ISupport{
+		CaseMapping:   "rfc1459",
+		NickLen:       16,
+		LineLen:       512,
+		ChanTypes:     "#&",
+		PrefixModes:   "ov",
+		PrefixSymbols: "@+",
+		ChanModes:     [4]string{"beI", "k", "l", "imnpst"},
+		Modes:         3,
+		TargMax:       make(map[string]int),
+		Raw:           make(map[string]string),
+	}
+}
+
+func (s *ISupport) copy() *ISupport {
+	cp := *s
+	cp.TargMax = make(map[string]int, len(s.TargMax))
+	for k, v := range s.TargMax {
+		cp.TargMax[k] = v
+	}
+	cp.Raw = make(map[string]string, len(s.Raw))
+	for k, v := range s.Raw {
+		cp.Raw[k] = v
+	}
+	return &cp
+}
+
+// parse rebuilds the typed fields from the raw tokens
+func (s *ISupport) parse() {
+	defaults := newISupport()
+	defaults.Raw = s.Raw
+	*s = *defaults
+	// malformed values like "NICKLEN=" keep the default
+	num := func(token string, def int) int {
+		if n, err := strconv.Atoi(s.Raw[token]); err == nil && n >= 0 {
+			return n
+		}
+		return def
+	}
+	if v, ok := s.Raw["CASEMAPPING"]; ok && v != "" {
+		s.CaseMapping = strings.ToLower(v)
+	}
+	s.Network = s.Raw["NETWORK"]
+	s.NickLen = num("NICKLEN", num("MAXNICKLEN", s.NickLen))
+	s.ChannelLen = num("CHANNELLEN", 0)
+	s.TopicLen = num("TOPICLEN", 0)
+	s.LineLen = num("LINELEN", s.LineLen)
+	if s.LineLen <= 0 {
+		s.LineLen = 512
+	}
+	s.Modes = num("MODES", s.Modes)
+	if v, ok := s.Raw["MODES"]; ok && v == "" {
+		// no value means no limit
+		s.Modes = 0
+	}
+	if v, ok := s.Raw["CHANTYPES"]; ok {
+		s.ChanTypes = v
+	}
+	if v, ok := s.Raw["PREFIX"]; ok {
+		s.PrefixModes, s.PrefixSymbols = "", ""
+		// "(qaohv)~&@%+"
+		if i := strings.IndexByte(v, ')'); strings.HasPrefix(v, "(") && i > 0 && len(v)-i-1 == i-1 {
+			s.PrefixModes, s.PrefixSymbols = v[1:i], v[i+1:]
+		}
+	}
+	if v, ok := s.Raw["CHANMODES"]; ok {
+		s.ChanModes = [4]string{}
+		copy(s.ChanModes[:], strings.SplitN(v, ",", 4))
+	}
+	if v, ok := s.Raw["TARGMAX"]; ok {
+		// "PRIVMSG:4,NOTICE:4,JOIN:"
+		for _, t := range strings.Split(v, ",") {
+			i := strings.IndexByte(t, ':')
+			if i < 0 {
+				continue
+			}
+			n, _ := strconv.Atoi(t[i+1:])
+			s.TargMax[strings.ToUpper(t[:i])] = n
+		}
+	}
+	s.StatusMsg = s.Raw["STATUSMSG"]
+	s.Bot = s.Raw["BOT"]
+	_, s.WhoX = s.Raw["WHOX"]
+	s.EList = strings.ToUpper(s.Raw["ELIST"])
+}
+
+// IsChannel reports whether the target is a channel name per CHANTYPES
+func (s *ISupport) IsChannel(target string) bool {
+	return target != "" && strings.IndexByte(s.ChanTypes, target[0]) >= 0
+}
+
+// ModeConfig returns the channel modes for the modes package
+func (s *ISupport) ModeConfig() modes.Config {
+	return modes.Config{ChanModes: s.ChanModes, PrefixModes: s.PrefixModes}
+}
+
+// ISupport returns a copy of the server features, defaults before registration
+func (c *Connection) ISupport() *ISupport {
+	c.isupportMu.Lock()
+	defer c.isupportMu.Unlock()
+	return c.isupport.copy()
+}
+
+// handleISupport collects the RPL_ISUPPORT (005) tokens
+func (c *Connection) handleISupport() {
+	c.addHandler(ISUPPORT, func(m *Message) {
+		// "005 nick TOKEN=value TOKEN -TOKEN :are supported by this server"
+		if len(m.Params) < 3 {
+			return
+		}
+		c.isupportMu.Lock()
+		defer c.isupportMu.Unlock()
+		for _, v := range m.Params[1 : len(m.Params)-1] {
+			if strings.HasPrefix(v, "-") {
+				delete(c.isupport.Raw, v[1:])
+				continue
+			}
+			if i := strings.IndexByte(v, '='); i >= 0 {
+				c.isupport.Raw[v[:i]] = unescapeISupport(v[i+1:])
+			} else {
+				c.isupport.Raw[v] = ""
+			}
+		}
+		c.isupport.parse()
+	})
+}
+
+// unescapeISupport decodes \xHH escapes in token values
+func unescapeISupport(v string) string {
+	if !strings.Contains(v, `\x`) {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+3 < len(v) && v[i+1] == 'x' {
+			if n, err := strconv.ParseUint(v[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package dumbirc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+func TestISupport(t *testing.T) {
+	s := newISupport()
+	for k, v := range map[string]string{
+		"NETWORK":     `Example\x20Net`,
+		"CASEMAPPING": "ascii",
+		"NICKLEN":     "30",
+		"CHANNELLEN":  "64",
+		"PREFIX":      "(qaohv)~&@%+",
+		"CHANMODES":   "beI,k,l,imnpstr",
+		"MODES":       "4",
+		"TARGMAX":     "PRIVMSG:4,NOTICE:4,JOIN:",
+		"CHANTYPES":   "#",
+		"WHOX":        "",
+	} {
+		s.Raw[k] = unescapeISupport(v)
+	}
+	s.parse()
+	if s.Network != "Example Net" {
+		t.Errorf("expected network %q, got %q", "Example Net", s.Network)
+	}
+	if s.CaseMapping != "ascii" || s.NickLen != 30 || s.ChannelLen != 64 || s.Modes != 4 || !s.WhoX {
+		t.Errorf("unexpected isupport values: %+v", s)
+	}
+	if s.PrefixModes != "qaohv" || s.PrefixSymbols != "~&@%+" {
+		t.Errorf("expected prefix (qaohv)~&@%%+, got (%s)%s", s.PrefixModes, s.PrefixSymbols)
+	}
+	if s.ChanModes != [4]string{"beI", "k", "l", "imnpstr"} {
+		t.Errorf("unexpected chanmodes %v", s.ChanModes)
+	}
+	if !reflect.DeepEqual(s.TargMax, map[string]int{"PRIVMSG": 4, "NOTICE": 4, "JOIN": 0}) {
+		t.Errorf("unexpected targmax %v", s.TargMax)
+	}
+	if !s.IsChannel("#test") || s.IsChannel("&test") {
+		t.Error("expected only # to be a channel type")
+	}
+	if s.LineLen != 512 {
+		t.Errorf("expected default line length 512, got %d", s.LineLen)
+	}
+	s.Raw["NICKLEN"], s.Raw["MODES"] = "", "x"
+	s.parse()
+	if def := newISupport(); s.NickLen != def.NickLen || s.Modes != def.Modes {
+		t.Errorf("expected malformed values to keep the defaults, got %d %d", s.NickLen, s.Modes)
+	}
+	s.Raw["MODES"] = ""
+	s.parse()
+	if s.Modes != 0 {
+		t.Errorf("expected MODES without a value to be no limit, got %d", s.Modes)
+	}
+}
+
+func TestChangeNick(t *testing.T) {
+	tt := []struct {
+		nick, expected string
+		max            int
+	}{
+		{"ugjka", "ugjka_", 16},
+		{"ugjka_", "ugjka__", 9},
+		{"ugjka____", "ugjka", 9},
+		{"abcdefghi", "abcde_", 9},
+		{"abcdefghi", "a_", 3},
+		{"abcdefghi", "a_", 0},
+	}
+	for _, tc := range tt {
+		if got := changeNick(tc.nick, tc.max); got != tc.expected {
+			t.Errorf("expected %s, got %s", tc.expected, got)
+		}
+	}
+}
+
+func TestMsgLineLen(t *testing.T) {
+	longmsg := strings.Repeat("*", 300)
+	prfxlen := len("PRIVMSG #test :") + len(nick) + 2
+	tt := []*irc.Message{
+		irc.ParseMessage(fmt.Sprintf("USER %s +iw * %s", nick, nick)),
+		irc.ParseMessage(fmt.Sprintf("NICK %s", nick)),
+		irc.ParseMessage(fmt.Sprintf("PRIVMSG #test :%s", longmsg[:254-prfxlen])),
+		irc.ParseMessage(fmt.Sprintf("PRIVMSG #test :%s", longmsg[254-prfxlen:])),
+	}
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	sync := make(chan struct{})
+	bot.AddCallback(ISUPPORT, func(m *Message) {
+		close(sync)
+	})
+	bot.Start()
+	srv.encode(fmt.Sprintf(":example.com 005 %s LINELEN=256 :are supported by this server", nick))
+	<-sync
+	go bot.Msg("#test", longmsg)
+	for _, tc := range tt {
+		msg, err := srv.decode()
+		if err != nil {
+			t.Errorf("decoding a message failed: %v", err)
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(tc, msg) {
+			t.Errorf("expected %v, got %v", tc, msg)
+		}
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,107 @@
+package dumbirc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// LAG is emitted for every answered PING, see Message.Lag
+const LAG = "LAG"
+
+const lagPrefix = "lag"
+
+// lagState tracks our PINGs that have not been answered yet
+type lagState struct {
+	mu      sync.Mutex
+	next    uint32
+	pending map[string]time.Time
+	lag     time.Duration
+}
+
+func (l *lagState) reset() {
+	l.mu.Lock()
+	l.pending = make(map[string]time.Time)
+	l.lag = 0
+	l.mu.Unlock()
+}
+
+// Lag returns the round trip time of the last answered PING, or how long
+// the oldest PING has been waiting if that is longer
+func (c *Connection) Lag() time.Duration {
+	c.lag.mu.Lock()
+	defer c.lag.mu.Unlock()
+	lag := c.lag.lag
+	for _, sent := range c.lag.pending {
+		if d := time.Since(sent); d > lag {
+			lag = d
+		}
+	}
+	return lag
+}
+
+// Lag returns the measured lag of a LAG event
+func (m *Message) Lag() time.Duration {
+	if m.Command != LAG || len(m.Params) == 0 {
+		return 0
+	}
+	d, _ := time.ParseDuration(m.Params[0])
+	return d
+}
+
+// pingToken registers a new PING, the connection is closed if it is not
+// answered within LagTimeout
+func (c *Connection) pingToken() string {
+	token := lagPrefix + strconv.FormatUint(uint64(atomic.AddUint32(&c.lag.next, 1)), 10)
+	c.lag.mu.Lock()
+	c.lag.pending[token] = time.Now()
+	c.lag.mu.Unlock()
+	if c.LagTimeout > 0 {
+		time.AfterFunc(c.LagTimeout, func() {
+			c.lag.mu.Lock()
+			_, late := c.lag.pending[token]
+			c.lag.mu.Unlock()
+			if late && c.IsConnected() {
+				c.Log.Printf("no pong in %v, disconnecting", c.LagTimeout)
+				c.disc.setReason(DisconnectPingTimeout, "")
+				c.close()
+			}
+		})
+	}
+	return token
+}
+
+// handleLag matches PONGs to our PINGs
+func (c *Connection) handleLag() {
+	c.addHandler(irc.PONG, func(m *Message) {
+		// ":server PONG server :token"
+		if len(m.Params) == 0 || !strings.HasPrefix(m.Trailing(), lagPrefix) {
+			return
+		}
+		token := m.Trailing()
+		c.lag.mu.Lock()
+		sent, ok := c.lag.pending[token]
+		if !ok {
+			c.lag.mu.Unlock()
+			return
+		}
+		// older PINGs were lost, this one answers for them
+		for k, v := range c.lag.pending {
+			if !v.After(sent) {
+				delete(c.lag.pending, k)
+			}
+		}
+		c.lag.lag = m.TimeStamp.Sub(sent)
+		lag := c.lag.lag
+		c.lag.mu.Unlock()
+		ev := NewMessage()
+		ev.Command = LAG
+		ev.Params = []string{lag.String()}
+		ev.Content = lag.String()
+		c.emit(LAG, ev)
+	})
+}
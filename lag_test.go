@@ -0,0 +1,64 @@
+package dumbirc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLag(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	events := make(chan *Message, 1)
+	bot.AddCallback(LAG, func(m *Message) {
+		events <- m
+	})
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	bot.Ping()
+	msg, _ := srv.decode()
+	if len(msg.Params) != 1 || msg.Params[0] != "lag1" {
+		t.Fatalf("expected PING lag1, got %v", msg)
+	}
+	time.Sleep(10 * time.Millisecond)
+	srv.encode("PONG example.com :fence1")
+	srv.encode("PONG example.com :lag1")
+	ev := <-events
+	if ev.Lag() < 10*time.Millisecond || ev.Lag() != bot.Lag() {
+		t.Errorf("expected lag of at least 10ms, got %v and %v", ev.Lag(), bot.Lag())
+	}
+	bot.Disconnect()
+	<-bot.Errchan
+	Destroy(bot)
+	srv.stop()
+}
+
+func TestLagTimeout(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.LagTimeout = 10 * time.Millisecond
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	bot.Ping()
+	srv.decode()
+	var de *DisconnectError
+	select {
+	case err := <-bot.Errchan:
+		if !errors.As(err, &de) || de.Reason != DisconnectPingTimeout {
+			t.Errorf("expected ping timeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected a disconnect")
+	}
+	if bot.Lag() < 10*time.Millisecond {
+		t.Errorf("expected the lag to include the unanswered ping, got %v", bot.Lag())
+	}
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,226 @@
+package dumbirc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// ListEntry is a channel from a LIST reply
+type ListEntry struct {
+	Channel string
+	Users   int
+	Topic   string
+}
+
+// ListFilter narrows down a LIST. Conditions the server does not advertise
+// in ISUPPORT ELIST are dropped, masks and user counts are then checked by us
+type ListFilter struct {
+	//Channel masks, e.g. "#go*", sent as the LIST target with ELIST M,
+	//without it only a single channel name is sent
+	Masks []string
+	//Channels not matching this mask (ELIST N)
+	NotMask string
+	//User counts (ELIST U), 0 means no limit
+	MinUsers int
+	MaxUsers int
+	//Topic changed within/before this long ago (ELIST T), 0 means no limit
+	TopicNewer time.Duration
+	TopicOlder time.Duration
+	//Channel created within/before this long ago (ELIST C), 0 means no limit
+	CreatedNewer time.Duration
+	CreatedOlder time.Duration
+}
+
+// params builds the LIST parameter from the conditions the server supports
+func (f ListFilter) params(elist string) string {
+	var p []string
+	if strings.Contains(elist, "M") {
+		p = append(p, f.Masks...)
+	} else if len(f.Masks) == 1 && !strings.ContainsAny(f.Masks[0], "*?") {
+		// a wildcard would be taken as a channel name
+		p = append(p, f.Masks[0])
+	}
+	if f.NotMask != "" && strings.Contains(elist, "N") {
+		p = append(p, "!"+f.NotMask)
+	}
+	minutes := func(d time.Duration) string {
+		return strconv.Itoa(int(d / time.Minute))
+	}
+	if strings.Contains(elist, "U") {
+		// the bounds are exclusive
+		if f.MinUsers > 0 {
+			p = append(p, ">"+strconv.Itoa(f.MinUsers-1))
+		}
+		if f.MaxUsers > 0 {
+			p = append(p, "<"+strconv.Itoa(f.MaxUsers+1))
+		}
+	}
+	if strings.Contains(elist, "T") {
+		if f.TopicNewer > 0 {
+			p = append(p, "T<"+minutes(f.TopicNewer))
+		}
+		if f.TopicOlder > 0 {
+			p = append(p, "T>"+minutes(f.TopicOlder))
+		}
+	}
+	if strings.Contains(elist, "C") {
+		if f.CreatedNewer > 0 {
+			p = append(p, "C<"+minutes(f.CreatedNewer))
+		}
+		if f.CreatedOlder > 0 {
+			p = append(p, "C>"+minutes(f.CreatedOlder))
+		}
+	}
+	return strings.Join(p, ",")
+}
+
+// match checks the conditions we can check ourselves
+func (f ListFilter) match(casemapping string, e ListEntry) bool {
+	if f.MinUsers > 0 && e.Users < f.MinUsers || f.MaxUsers > 0 && e.Users > f.MaxUsers {
+		return false
+	}
+	name := CaseFold(casemapping, e.Channel)
+	if f.NotMask != "" && wildMatch(CaseFold(casemapping, f.NotMask), name) {
+		return false
+	}
+	if len(f.Masks) == 0 {
+		return true
+	}
+	for _, v := range f.Masks {
+		if wildMatch(CaseFold(casemapping, v), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildMatch matches s against an IRC mask with * and ? wildcards
+func wildMatch(mask, s string) bool {
+	star, next := -1, 0
+	i, j := 0, 0
+	for j < len(s) {
+		switch {
+		case i < len(mask) && (mask[i] == '?' || mask[i] == s[j]):
+			i++
+			j++
+		case i < len(mask) && mask[i] == '*':
+			star, next = i, j
+			i++
+		case star >= 0:
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(mask) && mask[i] == '*' {
+		i++
+	}
+	return i == len(mask)
+}
+
+// ListStream delivers LIST results as they arrive
+type ListStream struct {
+	//Entries is closed after the last entry
+	Entries <-chan ListEntry
+	mu      sync.Mutex
+	err     error
+	done    chan struct{}
+}
+
+// Err returns why the listing ended early, valid once Entries is closed
+func (s *ListStream) Err() error {
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// List requests the channel list and streams the entries. Replies are queued
+// so a slow reader never holds up the connection, cancel ctx to stop early
+func (c *Connection) List(ctx context.Context, filter ListFilter) *ListStream {
+	out := make(chan ListEntry)
+	s := &ListStream{Entries: out, done: make(chan struct{})}
+	mu := &s.mu
+	var (
+		queue  []ListEntry
+		ended  bool
+		notify = make(chan struct{}, 1)
+	)
+	wake := func() {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+	go func() {
+		// LIST replies carry nothing to tell two listings apart
+		c.listMu.Lock()
+		defer c.listMu.Unlock()
+		is := c.ISupport()
+		cmd := irc.LIST
+		if p := filter.params(is.EList); p != "" {
+			cmd += " " + p
+		}
+		err := c.request(ctx, cmd, func(m *Message) (bool, error) {
+			switch m.Command {
+			case irc.RPL_LIST:
+				// "322 me #chan users :topic"
+				if len(m.Params) < 3 {
+					return false, nil
+				}
+				e := ListEntry{Channel: m.Params[1], Topic: m.Trailing()}
+				e.Users, _ = strconv.Atoi(m.Params[2])
+				if len(m.Params) == 3 {
+					e.Topic = ""
+				}
+				if filter.match(is.CaseMapping, e) {
+					mu.Lock()
+					queue = append(queue, e)
+					mu.Unlock()
+					wake()
+				}
+			case irc.RPL_LISTEND:
+				return true, nil
+			}
+			return false, nil
+		})
+		mu.Lock()
+		s.err, ended = err, true
+		mu.Unlock()
+		wake()
+	}()
+	go func() {
+		defer close(s.done)
+		defer close(out)
+		for {
+			mu.Lock()
+			pending := queue
+			queue = nil
+			finished := ended
+			mu.Unlock()
+			for _, e := range pending {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					mu.Lock()
+					s.err = ctx.Err()
+					mu.Unlock()
+					return
+				}
+			}
+			if finished && len(pending) == 0 {
+				return
+			}
+			if len(pending) == 0 {
+				<-notify
+			}
+		}
+	}()
+	return s
+}
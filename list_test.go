@@ -0,0 +1,80 @@
+package dumbirc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListFilter(t *testing.T) {
+	filter := ListFilter{Masks: []string{"#go*"}, NotMask: "*-off*", MinUsers: 5, TopicNewer: time.Hour}
+	if p := filter.params("MNUT"); p != "#go*,!*-off*,>4,T<60" {
+		t.Errorf("expected #go*,!*-off*,>4,T<60, got %s", p)
+	}
+	if p := filter.params(""); p != "" {
+		t.Errorf("expected no mask without ELIST M, got %s", p)
+	}
+	if p := (ListFilter{Masks: []string{"#go"}}).params(""); p != "#go" {
+		t.Errorf("expected #go, got %s", p)
+	}
+	tt := []struct {
+		entry ListEntry
+		match bool
+	}{
+		{ListEntry{Channel: "#Go-nuts", Users: 10}, true},
+		{ListEntry{Channel: "#go-offtopic", Users: 10}, false},
+		{ListEntry{Channel: "#go", Users: 4}, false},
+		{ListEntry{Channel: "#rust", Users: 10}, false},
+	}
+	for _, tc := range tt {
+		if filter.match("rfc1459", tc.entry) != tc.match {
+			t.Errorf("expected match %v for %+v", tc.match, tc.entry)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	list := bot.List(context.Background(), ListFilter{MinUsers: 2})
+	if msg, _ := srv.decode(); msg.String() != "LIST" {
+		t.Fatalf("expected LIST, got %v", msg)
+	}
+	srv.encode(fmt.Sprintf(":example.com 321 %s Channel :Users Name", nick))
+	srv.encode(fmt.Sprintf(":example.com 322 %s #one 1 :lonely", nick))
+	srv.encode(fmt.Sprintf(":example.com 322 %s #two 2 :[+nt] a topic", nick))
+	srv.encode(fmt.Sprintf(":example.com 322 %s #three 3 :", nick))
+	srv.encode(fmt.Sprintf(":example.com 323 %s :End of /LIST", nick))
+	var entries []ListEntry
+	for e := range list.Entries {
+		entries = append(entries, e)
+	}
+	expected := []ListEntry{{"#two", 2, "[+nt] a topic"}, {"#three", 3, ""}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+	if err := list.Err(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	list = bot.List(ctx, ListFilter{})
+	srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 322 %s #one 1 :lonely", nick))
+	<-list.Entries
+	cancel()
+	for range list.Entries {
+	}
+	if err := list.Err(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,170 @@
+// Package modes parses and builds IRC channel mode changes
+// per the CHANMODES and PREFIX tokens of RPL_ISUPPORT
+package modes
+
+import (
+	"strings"
+)
+
+// Type of a channel mode
+type Type int
+
+// Mode types, A to D as in CHANMODES
+const (
+	//Unknown to the server's CHANMODES and PREFIX, treated as a flag
+	Unknown Type = iota
+	//A, list modes like bans, always have a parameter
+	List
+	//B, always have a parameter
+	Param
+	//C, have a parameter only when set
+	SetParam
+	//D, never have a parameter
+	Flag
+	//Status modes from PREFIX like op and voice, always have a nick
+	Prefix
+)
+
+// Config describes the modes a server supports
+type Config struct {
+	//CHANMODES types A, B, C and D
+	ChanModes [4]string
+	//The modes of PREFIX, e.g. "ov"
+	PrefixModes string
+}
+
+// Change is a single mode change
+type Change struct {
+	Add  bool
+	Mode byte
+	Arg  string
+	Type Type
+}
+
+// String returns the change as "+o nick"
+func (c Change) String() string {
+	s := "-"
+	if c.Add {
+		s = "+"
+	}
+	s += string(c.Mode)
+	if c.Arg != "" {
+		s += " " + c.Arg
+	}
+	return s
+}
+
+// Many returns the same change for several parameters, e.g. voicing many nicks
+func Many(add bool, mode byte, args ...string) []Change {
+	changes := make([]Change, 0, len(args))
+	for _, v := range args {
+		changes = append(changes, Change{Add: add, Mode: mode, Arg: v})
+	}
+	return changes
+}
+
+// Type returns the type of a mode
+func (c Config) Type(mode byte) Type {
+	switch {
+	case strings.IndexByte(c.PrefixModes, mode) >= 0:
+		return Prefix
+	case strings.IndexByte(c.ChanModes[0], mode) >= 0:
+		return List
+	case strings.IndexByte(c.ChanModes[1], mode) >= 0:
+		return Param
+	case strings.IndexByte(c.ChanModes[2], mode) >= 0:
+		return SetParam
+	case strings.IndexByte(c.ChanModes[3], mode) >= 0:
+		return Flag
+	}
+	return Unknown
+}
+
+// takesArg reports whether the change carries a parameter
+func (t Type) takesArg(add bool) bool {
+	switch t {
+	case Prefix, List, Param:
+		return true
+	case SetParam:
+		return add
+	}
+	return false
+}
+
+// Parse parses a mode string like "+ov-b" and its parameters into changes.
+// List modes without a parameter (a list query like "+b") get an empty Arg
+func (c Config) Parse(modes string, args ...string) (changes []Change) {
+	add := true
+	for i := 0; i < len(modes); i++ {
+		mode := modes[i]
+		switch mode {
+		case '+':
+			add = true
+			continue
+		case '-':
+			add = false
+			continue
+		}
+		change := Change{Add: add, Mode: mode, Type: c.Type(mode)}
+		if change.Type.takesArg(add) && len(args) > 0 {
+			change.Arg = args[0]
+			args = args[1:]
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// Format batches changes into as few mode strings with parameters as possible,
+// e.g. "+ov-b nick1 nick2 *!*@host". max limits the changes with a parameter
+// per line (ISUPPORT MODES), maxLen the length of a line, 0 means no limit
+func (c Config) Format(changes []Change, max, maxLen int) (lines []string) {
+	var modes, args strings.Builder
+	sign, withArgs := byte(0), 0
+	flush := func() {
+		if modes.Len() == 0 {
+			return
+		}
+		line := modes.String()
+		if args.Len() > 0 {
+			line += args.String()
+		}
+		lines = append(lines, line)
+		modes.Reset()
+		args.Reset()
+		sign, withArgs = 0, 0
+	}
+	for _, v := range changes {
+		s := byte('-')
+		if v.Add {
+			s = '+'
+		}
+		arg := ""
+		if c.Type(v.Mode).takesArg(v.Add) || (c.Type(v.Mode) == Unknown && v.Arg != "") {
+			arg = v.Arg
+		}
+		grow := 1 + len(arg)
+		if arg != "" {
+			grow++
+		}
+		if s != sign {
+			grow++
+		}
+		full := arg != "" && max > 0 && withArgs >= max
+		long := maxLen > 0 && modes.Len()+args.Len()+grow > maxLen
+		if full || long {
+			flush()
+		}
+		if s != sign {
+			modes.WriteByte(s)
+			sign = s
+		}
+		modes.WriteByte(v.Mode)
+		if arg != "" {
+			args.WriteString(" " + arg)
+			withArgs++
+		}
+	}
+	flush()
+	return lines
+}
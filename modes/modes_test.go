@@ -0,0 +1,43 @@
+package modes
+
+import (
+	"reflect"
+	"testing"
+)
+
+var config = Config{
+	ChanModes:   [4]string{"beI", "k", "l", "imnpst"},
+	PrefixModes: "qaohv",
+}
+
+func TestParse(t *testing.T) {
+	expected := []Change{
+		{Add: true, Mode: 'o', Arg: "nick1", Type: Prefix},
+		{Add: true, Mode: 'v', Arg: "nick2", Type: Prefix},
+		{Add: false, Mode: 'b', Arg: "*!*@host", Type: List},
+		{Add: false, Mode: 'l', Type: SetParam},
+		{Add: true, Mode: 'l', Arg: "10", Type: SetParam},
+		{Add: true, Mode: 'k', Arg: "key", Type: Param},
+		{Add: true, Mode: 'n', Type: Flag},
+		{Add: true, Mode: 'X', Type: Unknown},
+	}
+	changes := config.Parse("+ov-bl+lknX", "nick1", "nick2", "*!*@host", "10", "key")
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	changes := append(Many(true, 'v', "a", "b", "c", "d"), Change{Add: false, Mode: 'b', Arg: "*!*@host"}, Change{Add: true, Mode: 'm'})
+	expected := []string{"+vvv a b c", "+v-b+m d *!*@host"}
+	if lines := config.Format(changes, 3, 0); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+	expected = []string{"+vv a b", "+vv c d"}
+	if lines := config.Format(Many(true, 'v', "a", "b", "c", "d"), 0, 8); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+	if lines := config.Format(config.Parse("+ov-bl+lknX", "nick1", "nick2", "*!*@host", "10", "key"), 0, 0); !reflect.DeepEqual(lines, []string{"+ov-bl+lknX nick1 nick2 *!*@host 10 key"}) {
+		t.Errorf("expected round trip, got %q", lines)
+	}
+}
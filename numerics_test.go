@@ -0,0 +1,109 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+func TestNumericName(t *testing.T) {
+	for code, name := range map[string]string{
+		"001": "RPL_WELCOME",
+		"005": "RPL_ISUPPORT",
+		"354": "RPL_WHOSPCRPL",
+		"474": "ERR_BANNEDFROMCHAN",
+		"730": "RPL_MONONLINE",
+		"999": "",
+	} {
+		if got := NumericName(code); got != name {
+			t.Errorf("%s: expected %q, got %q", code, name, got)
+		}
+	}
+}
+
+func TestErrorFromMessage(t *testing.T) {
+	for _, v := range []string{
+		":example.com 001 ugjka :Welcome",
+		":ugjka!ugjka@host PRIVMSG #test :hi",
+		":example.com 900 ugjka ugjka!u@h acc :You are now logged in",
+	} {
+		if err := ErrorFromMessage(ParseMessage(irc.ParseMessage(v))); err != nil {
+			t.Errorf("%s: expected no error, got %v", v, err)
+		}
+	}
+	err := ErrorFromMessage(ParseMessage(irc.ParseMessage(":example.com 441 ugjka other #test :They aren't on that channel")))
+	var re *ReplyError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected *ReplyError, got %v", err)
+	}
+	expected := &ReplyError{
+		Code:   "441",
+		Name:   "ERR_USERNOTINCHANNEL",
+		Target: "other",
+		Params: []string{"other", "#test"},
+		Text:   "They aren't on that channel",
+	}
+	if !reflect.DeepEqual(re, expected) {
+		t.Errorf("expected %+v, got %+v", expected, re)
+	}
+	if !errors.Is(err, ErrUserNotInChannel) || errors.Is(err, ErrNotOnChannel) {
+		t.Errorf("errors.Is mismatch for %v", err)
+	}
+	if err.Error() != "ERR_USERNOTINCHANNEL other: They aren't on that channel" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	err = ErrorFromMessage(ParseMessage(irc.ParseMessage(":example.com 489 ugjka #test :Cannot join channel (+z)")))
+	if !errors.As(err, &re) || re.Name != "" || re.Target != "#test" {
+		t.Errorf("expected unnamed error for #test, got %v", err)
+	}
+}
+
+func TestJoinWait(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	results := make(chan error)
+	go func() {
+		results <- bot.JoinWait(context.Background(), "#banned")
+	}()
+	srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 474 %s #other :Cannot join channel (+b)", nick))
+	srv.encode(fmt.Sprintf(":example.com 474 %s #banned :Cannot join channel (+b)", nick))
+	var re *ReplyError
+	if err := <-results; !errors.Is(err, ErrBannedFromChan) || !errors.As(err, &re) || re.Target != "#banned" {
+		t.Errorf("expected ErrBannedFromChan for #banned, got %v", err)
+	}
+	go func() {
+		results <- bot.JoinWait(context.Background(), "#test", "key")
+	}()
+	msg, _ := srv.decode()
+	if msg.String() != "JOIN #test key" {
+		t.Errorf("expected JOIN #test key, got %v", msg)
+	}
+	srv.encode(":other!u@h JOIN #test")
+	srv.encode(fmt.Sprintf(":%s!u@h JOIN #test", nick))
+	if err := <-results; err != nil {
+		t.Errorf("expected join, got %v", err)
+	}
+	go func() {
+		results <- bot.CmdWait(context.Background(), "FOO bar", "bar")
+	}()
+	if msg, _ := srv.decode(); msg.Command != "FOO" {
+		t.Errorf("expected FOO, got %v", msg)
+	}
+	srv.encode(fmt.Sprintf(":example.com 421 %s FOO :Unknown command", nick))
+	if err := <-results; !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("expected ErrUnknownCommand, got %v", err)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,292 @@
+package dumbirc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// ErrNoOutbox is returned by the Durable send functions without SetOutbox
+var ErrNoOutbox = errors.New("no outbox set")
+
+// OutboxEntry is a message waiting in the outbox
+type OutboxEntry struct {
+	ID uint64
+	//PRIVMSG or NOTICE
+	Command string
+	Target  string
+	Text    string
+	//When the message was sent, for the max age
+	Time time.Time
+}
+
+// Outbox stores messages until they are delivered, see SetOutbox
+type Outbox interface {
+	//Put stores an entry and sets its ID
+	Put(e *OutboxEntry) error
+	//Entries returns the stored entries, oldest first
+	Entries() ([]OutboxEntry, error)
+	//Remove forgets a delivered or expired entry
+	Remove(id uint64) error
+}
+
+// MemoryOutbox keeps up to a number of entries in memory,
+// they survive reconnects but not restarts
+type MemoryOutbox struct {
+	mu      sync.Mutex
+	max     int
+	next    uint64
+	entries []OutboxEntry
+}
+
+// NewMemoryOutbox returns an outbox holding at most max entries, 0 is no limit
+func NewMemoryOutbox(max int) *MemoryOutbox {
+	return &MemoryOutbox{max: max}
+}
+
+// Put stores an entry, returns ErrQueueFull when the outbox is full
+func (o *MemoryOutbox) Put(e *OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.put(e)
+}
+
+func (o *MemoryOutbox) put(e *OutboxEntry) error {
+	if o.max > 0 && len(o.entries) >= o.max {
+		return ErrQueueFull
+	}
+	o.next++
+	e.ID = o.next
+	o.entries = append(o.entries, *e)
+	return nil
+}
+
+// Entries returns the stored entries, oldest first
+func (o *MemoryOutbox) Entries() ([]OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]OutboxEntry(nil), o.entries...), nil
+}
+
+// Remove forgets an entry
+func (o *MemoryOutbox) Remove(id uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.remove(id)
+	return nil
+}
+
+func (o *MemoryOutbox) remove(id uint64) {
+	for i, v := range o.entries {
+		if v.ID == id {
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+			break
+		}
+	}
+}
+
+// FileOutbox is a MemoryOutbox saved to a JSON file on every change,
+// so messages survive restarts too
+type FileOutbox struct {
+	MemoryOutbox
+	path string
+}
+
+// NewFileOutbox loads the outbox saved at path, a missing file is an empty outbox
+func NewFileOutbox(path string, max int) (*FileOutbox, error) {
+	o := &FileOutbox{MemoryOutbox: MemoryOutbox{max: max}, path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &o.entries); err != nil {
+		return nil, err
+	}
+	for _, v := range o.entries {
+		if v.ID > o.next {
+			o.next = v.ID
+		}
+	}
+	return o, nil
+}
+
+// save writes the entries to a temporary file and renames it over the old one,
+// the lock must be held
+func (o *FileOutbox) save() error {
+	data, err := json.Marshal(o.entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(o.path), filepath.Base(o.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	// or a crash may leave an empty file behind the rename
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), o.path)
+}
+
+// Put stores an entry and saves the outbox
+func (o *FileOutbox) Put(e *OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.put(e); err != nil {
+		return err
+	}
+	if err := o.save(); err != nil {
+		o.remove(e.ID)
+		return err
+	}
+	return nil
+}
+
+// Remove forgets an entry and saves the outbox
+func (o *FileOutbox) Remove(id uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.remove(id)
+	return o.save()
+}
+
+// outboxState is the outbox of a connection
+type outboxState struct {
+	mu     sync.Mutex
+	outbox Outbox
+	maxAge time.Duration
+	//serializes deliveries so nothing is sent twice
+	flushMu sync.Mutex
+}
+
+// SetOutbox makes MsgDurable and NoticeDurable store messages in o until
+// they are delivered, across reconnects. Messages to a channel wait until
+// we are in it again, messages older than maxAge are dropped, 0 keeps them
+func (c *Connection) SetOutbox(o Outbox, maxAge time.Duration) {
+	c.outbox.mu.Lock()
+	c.outbox.outbox, c.outbox.maxAge = o, maxAge
+	c.outbox.mu.Unlock()
+	go c.flushOutbox()
+}
+
+// MsgDurable sends a privmessage through the outbox, it is delivered
+// once we are registered and in the target channel
+func (c *Connection) MsgDurable(dest, msg string) error {
+	return c.putOutbox(irc.PRIVMSG, dest, msg)
+}
+
+// NoticeDurable sends a notice through the outbox
+func (c *Connection) NoticeDurable(dest, msg string) error {
+	return c.putOutbox(irc.NOTICE, dest, msg)
+}
+
+func (c *Connection) putOutbox(command, dest, msg string) error {
+	if err := checkTarget(dest); err != nil {
+		return err
+	}
+	c.outbox.mu.Lock()
+	o := c.outbox.outbox
+	c.outbox.mu.Unlock()
+	if o == nil {
+		return ErrNoOutbox
+	}
+	err := o.Put(&OutboxEntry{Command: command, Target: dest, Text: msg, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	go c.flushOutbox()
+	return nil
+}
+
+// outboxReady reports whether an entry can be delivered now
+func (c *Connection) outboxReady(e *OutboxEntry) bool {
+	if !c.IsConnected() || !c.Registered() {
+		return false
+	}
+	return !c.ISupport().IsChannel(e.Target) || c.Channel(e.Target) != nil
+}
+
+// flushOutbox delivers what can be delivered and drops expired entries
+func (c *Connection) flushOutbox() {
+	c.outbox.mu.Lock()
+	o, maxAge := c.outbox.outbox, c.outbox.maxAge
+	c.outbox.mu.Unlock()
+	if o == nil {
+		return
+	}
+	c.outbox.flushMu.Lock()
+	defer c.outbox.flushMu.Unlock()
+	entries, err := o.Entries()
+	if err != nil {
+		c.Log.Printf("outbox: %v", err)
+		return
+	}
+	for i := range entries {
+		e := &entries[i]
+		if maxAge > 0 && time.Since(e.Time) > maxAge {
+			c.Log.Printf("outbox: dropping expired message to %s", e.Target)
+			o.Remove(e.ID)
+			continue
+		}
+		if !c.outboxReady(e) {
+			continue
+		}
+		// entries loaded from a file were never checked
+		err := checkTarget(e.Target)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), c.joinTimeout)
+			err = c.sendContext(ctx, PriorityNormal, true, c.split(e.Command, e.Target, e.Text))
+			cancel()
+		}
+		switch err {
+		case nil:
+		case ErrNotConnected, ErrQueueFull:
+			// kept for the next try
+			c.Log.Printf("outbox: %v", err)
+			return
+		case context.DeadlineExceeded, ErrCancelled:
+			// kept for the next try, the rest may still go out
+			c.Log.Printf("outbox: message to %s: %v", e.Target, err)
+			continue
+		default:
+			// it would fail again and again
+			c.Log.Printf("outbox: dropping message to %s: %v", e.Target, err)
+		}
+		if err := o.Remove(e.ID); err != nil {
+			c.Log.Printf("outbox: %v", err)
+		}
+	}
+}
+
+// handleOutbox delivers the outbox after registration and on joins
+func (c *Connection) handleOutbox() {
+	c.addHandler(WELCOME, func(m *Message) {
+		go c.flushOutbox()
+	})
+	c.addHandler(JOIN, func(m *Message) {
+		if c.fromMe(m) {
+			go c.flushOutbox()
+		}
+	})
+}
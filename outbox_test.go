@@ -0,0 +1,95 @@
+package dumbirc
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryOutbox(t *testing.T) {
+	o := NewMemoryOutbox(2)
+	for i := 0; i < 2; i++ {
+		if err := o.Put(&OutboxEntry{Target: "#test", Text: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Put(&OutboxEntry{Target: "#test", Text: "hi"}); err != ErrQueueFull {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	o.Remove(1)
+	if entries, _ := o.Entries(); len(entries) != 1 || entries[0].ID != 2 {
+		t.Errorf("expected entry 2 left, got %+v", entries)
+	}
+}
+
+func TestFileOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	o, err := NewFileOutbox(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Put(&OutboxEntry{Command: "PRIVMSG", Target: "#a", Text: "one"})
+	o.Put(&OutboxEntry{Command: "PRIVMSG", Target: "#b", Text: "two"})
+	o.Remove(1)
+	o, err = NewFileOutbox(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := o.Entries()
+	if len(entries) != 1 || entries[0].Text != "two" {
+		t.Fatalf("expected the second entry after reload, got %+v", entries)
+	}
+	o.Put(&OutboxEntry{Command: "PRIVMSG", Target: "#c", Text: "three"})
+	if entries, _ := o.Entries(); entries[1].ID != 3 {
+		t.Errorf("expected new IDs after the saved ones, got %d", entries[1].ID)
+	}
+}
+
+func TestOutboxDelivery(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	if err := bot.MsgDurable("#test", "lost"); err != ErrNoOutbox {
+		t.Errorf("expected ErrNoOutbox, got %v", err)
+	}
+	o := NewMemoryOutbox(0)
+	o.Put(&OutboxEntry{Command: "PRIVMSG", Target: "#test", Text: "expired", Time: time.Now().Add(-time.Hour)})
+	bot.SetOutbox(o, time.Minute)
+	if err := bot.MsgDurable("#test", "alert"); err != nil {
+		t.Fatal(err)
+	}
+	// can never be sent, it must not hold up the rest
+	o.Put(&OutboxEntry{Command: "NOTICE", Target: "bad target", Text: "x", Time: time.Now()})
+	if err := bot.NoticeDurable("someone", "direct"); err != nil {
+		t.Fatal(err)
+	}
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	srv.encode(fmt.Sprintf(":example.com 001 %s :Welcome", nick))
+	msg, _ := srv.decode()
+	if msg.String() != "NOTICE someone direct" {
+		t.Errorf("expected the notice after the welcome, got %v", msg)
+	}
+	srv.encode(fmt.Sprintf(":%s!u@h JOIN #test", nick))
+	for msg, _ = srv.decode(); msg.Command != "PRIVMSG"; msg, _ = srv.decode() {
+	}
+	if msg.String() != "PRIVMSG #test alert" {
+		t.Errorf("expected the alert after the join, got %v", msg)
+	}
+	// delivered entries are removed right after they are written
+	deadline := time.Now().Add(time.Second)
+	entries, _ := o.Entries()
+	for len(entries) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		entries, _ = o.Entries()
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected an empty outbox, got %+v", entries)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,384 @@
+package dumbirc
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// Priority of an outgoing line, higher priorities are sent first
+type Priority int
+
+// Priorities
+const (
+	//Announcements and other mass messages, they yield to everything else
+	PriorityBulk Priority = iota
+	//Messages and commands
+	PriorityNormal
+	//MODE and KICK, so moderation is not stuck behind chatter
+	PriorityModeration
+	//PONG, QUIT and nick changes, these are not throttled
+	PriorityControl
+	priorities
+)
+
+// priorityOf picks the priority of a line by its command
+func priorityOf(line string) Priority {
+	if strings.HasPrefix(line, "@") {
+		// skip the message tags
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+	cmd := line
+	if i := strings.IndexByte(cmd, ' '); i >= 0 {
+		cmd = cmd[:i]
+	}
+	switch strings.ToUpper(cmd) {
+	case irc.PONG, irc.QUIT, irc.NICK:
+		return PriorityControl
+	case irc.MODE, irc.KICK:
+		return PriorityModeration
+	}
+	return PriorityNormal
+}
+
+// lineTarget returns the first parameter of a line, the channel or nick
+// most commands are about
+func lineTarget(line string) string {
+	if strings.HasPrefix(line, "@") {
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 {
+		return ""
+	}
+	return strings.TrimPrefix(fields[1], ":")
+}
+
+// outLine is a queued line
+type outLine struct {
+	line string
+	//the SendGroup that queued it, 0 for none
+	group uint64
+	//the atomic block it belongs to, 0 for none
+	block uint64
+	//shared by the lines of one send
+	wait *sendWait
+	last bool
+}
+
+// sendWait tells whoever queued a send what became of it
+type sendWait struct {
+	//closed once the last line is written
+	done chan struct{}
+	//closed when the last line is dropped before it is written
+	cancelled chan struct{}
+}
+
+// lane holds the lines of one priority, a FIFO per target served round-robin
+// so one busy target can't starve the others
+type lane struct {
+	targets map[string][]outLine
+	//targets with lines, the next one to send first
+	order []string
+}
+
+func (l *lane) push(target string, lines []outLine) {
+	if l.targets == nil {
+		l.targets = make(map[string][]outLine)
+	}
+	if len(l.targets[target]) == 0 {
+		l.order = append(l.order, target)
+	}
+	l.targets[target] = append(l.targets[target], lines...)
+}
+
+func (l *lane) peek() (outLine, bool) {
+	if len(l.order) == 0 {
+		return outLine{}, false
+	}
+	return l.targets[l.order[0]][0], true
+}
+
+// pop returns the next line, the target stays first while
+// the rest of its atomic block is waiting
+func (l *lane) pop() (outLine, bool) {
+	if len(l.order) == 0 {
+		return outLine{}, false
+	}
+	target := l.order[0]
+	lines := l.targets[target]
+	line := lines[0]
+	if len(lines) == 1 {
+		delete(l.targets, target)
+		l.order = l.order[1:]
+	} else if l.targets[target] = lines[1:]; line.block == 0 || lines[1].block != line.block {
+		// back of the line for this target
+		l.order = append(l.order[1:], target)
+	}
+	return line, true
+}
+
+// remove drops the lines of target, or all targets when all is set,
+// for which drop returns true
+func (l *lane) remove(target string, all bool, drop func(outLine) bool) int {
+	n := 0
+	order := l.order[:0]
+	for _, t := range l.order {
+		if !all && t != target {
+			order = append(order, t)
+			continue
+		}
+		kept := l.targets[t][:0]
+		for _, v := range l.targets[t] {
+			if drop(v) {
+				n++
+				if v.last {
+					close(v.wait.cancelled)
+				}
+			} else {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(l.targets, t)
+			continue
+		}
+		l.targets[t] = kept
+		order = append(order, t)
+	}
+	l.order = order
+	return n
+}
+
+// outQueue holds the lines waiting for writeLoop, a lane per priority
+type outQueue struct {
+	mu    sync.Mutex
+	lanes [priorities]lane
+	//an atomic block is being sent from this lane, only control lines
+	//may go in between, noLane if none
+	locked    Priority
+	lockBlock uint64
+	//lines queued
+	count int
+	//signals writeLoop that a line was queued
+	wake chan struct{}
+}
+
+func newOutQueue() *outQueue {
+	return &outQueue{wake: make(chan struct{}, 1), locked: noLane}
+}
+
+const noLane Priority = -1
+
+func (q *outQueue) reset() {
+	q.mu.Lock()
+	for i := range q.lanes {
+		q.lanes[i] = lane{}
+	}
+	q.locked = noLane
+	q.count = 0
+	q.mu.Unlock()
+}
+
+// push queues lines for a casefolded target, they are sent in order
+func (q *outQueue) push(p Priority, target string, lines ...string) {
+	q.pushGroup(p, target, 0, lines...)
+}
+
+func (q *outQueue) pushGroup(p Priority, target string, group uint64, lines ...string) {
+	out := make([]outLine, len(lines))
+	for i, v := range lines {
+		out[i] = outLine{line: v, group: group}
+	}
+	q.pushLines(p, target, out, 0)
+}
+
+// pushLines queues lines unless that makes more than max lines queued,
+// max 0 is no limit. Control lines are never refused
+func (q *outQueue) pushLines(p Priority, target string, out []outLine, max int) bool {
+	if len(out) == 0 {
+		return true
+	}
+	if p < PriorityBulk {
+		p = PriorityBulk
+	} else if p > PriorityControl {
+		p = PriorityControl
+	}
+	q.mu.Lock()
+	if max > 0 && p != PriorityControl && q.count+len(out) > max {
+		q.mu.Unlock()
+		return false
+	}
+	q.lanes[p].push(target, out)
+	q.count += len(out)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// next returns the lane to send from, the lock must be held
+func (q *outQueue) next() (Priority, bool) {
+	if len(q.lanes[PriorityControl].order) > 0 {
+		return PriorityControl, true
+	}
+	if q.locked != noLane {
+		return q.locked, true
+	}
+	for p := PriorityControl; p >= PriorityBulk; p-- {
+		if len(q.lanes[p].order) > 0 {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// peek returns the next line and its priority, false if the queue is empty
+func (q *outQueue) peek() (string, Priority, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, ok := q.next()
+	if !ok {
+		return "", 0, false
+	}
+	line, _ := q.lanes[p].peek()
+	return line.line, p, true
+}
+
+// pop removes the next line with the highest priority
+func (q *outQueue) pop() (outLine, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, ok := q.next()
+	if !ok {
+		return outLine{}, false
+	}
+	line, _ := q.lanes[p].pop()
+	q.count--
+	if p == q.locked || q.locked == noLane {
+		q.locked = noLane
+		if next, ok := q.lanes[p].peek(); ok && line.block != 0 && next.block == line.block {
+			q.locked, q.lockBlock = p, line.block
+		}
+	}
+	return line, true
+}
+
+// remove drops queued lines, see lane.remove
+func (q *outQueue) remove(target string, all bool, drop func(outLine) bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for i := range q.lanes {
+		n += q.lanes[i].remove(target, all, drop)
+	}
+	q.count -= n
+	// the rest of the block being sent may be gone
+	if q.locked != noLane {
+		if next, ok := q.lanes[q.locked].peek(); !ok || next.block != q.lockBlock {
+			q.locked = noLane
+		}
+	}
+	return n
+}
+
+// QueueLen returns how many lines are waiting to be sent
+func (c *Connection) QueueLen() int {
+	c.queue.mu.Lock()
+	defer c.queue.mu.Unlock()
+	return c.queue.count
+}
+
+// sendPriority queues lines with the given priority, they are queued
+// for the target of the first line and are sent in order
+func (c *Connection) sendPriority(p Priority, lines ...string) {
+	if _, err := c.enqueue(p, 0, false, lines); err == ErrQueueFull {
+		c.Log.Printf("send queue full, dropped %d lines", len(lines))
+	}
+}
+
+// enqueue queues lines for the target of the first line, they are sent in
+// order, contiguously when atomic is set. The returned sendWait tells
+// when the last line is written or dropped
+func (c *Connection) enqueue(p Priority, group uint64, atomic bool, lines []string) (*sendWait, error) {
+	if !c.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	wait := &sendWait{done: make(chan struct{}), cancelled: make(chan struct{})}
+	if len(lines) == 0 {
+		close(wait.done)
+		return wait, nil
+	}
+	var block uint64
+	if atomic {
+		block = c.nextBlock()
+	}
+	out := make([]outLine, len(lines))
+	for i, v := range lines {
+		out[i] = outLine{line: v, group: group, block: block, wait: wait}
+	}
+	out[len(out)-1].last = true
+	if !c.queue.pushLines(p, c.Fold(lineTarget(lines[0])), out, c.MaxQueue) {
+		return nil, ErrQueueFull
+	}
+	return wait, nil
+}
+
+// CmdPriority sends a raw command with the given priority
+func (c *Connection) CmdPriority(p Priority, command string) {
+	c.sendPriority(p, command)
+}
+
+// SendAtomic queues lines that are sent one after another, no other line
+// goes in between except for control lines like PONG. The lines are queued
+// with the priority and for the target of the first line
+func (c *Connection) SendAtomic(lines ...string) {
+	if len(lines) == 0 {
+		return
+	}
+	c.sendAtomic(priorityOf(lines[0]), lines)
+}
+
+// MsgAtomic sends a privmessage for each of lines, e.g. the rows of a table,
+// without other messages interleaving them
+func (c *Connection) MsgAtomic(dest string, lines ...string) {
+	var out []string
+	for _, v := range lines {
+		out = append(out, c.split(irc.PRIVMSG, dest, v)...)
+	}
+	c.sendAtomic(PriorityNormal, out)
+}
+
+func (c *Connection) sendAtomic(p Priority, lines []string) {
+	if _, err := c.enqueue(p, 0, true, lines); err == ErrQueueFull {
+		c.Log.Printf("send queue full, dropped %d lines", len(lines))
+	}
+}
+
+func (c *Connection) nextBlock() uint64 {
+	return uint64(atomic.AddUint32(&c.blocks, 1))
+}
+
+// MsgPriority sends a privmessage with the given priority,
+// use PriorityBulk for announcements that should not delay anything else
+func (c *Connection) MsgPriority(p Priority, dest, msg string) {
+	lines, err := c.msgLines(irc.PRIVMSG, dest, msg)
+	c.sendLogged(p, 0, lines, err)
+}
+
+// Quit quits with a reason, it jumps ahead of everything queued
+// and the server closes the connection after it
+func (c *Connection) Quit(reason string) {
+	if c.IsConnected() {
+		c.disc.setReason(DisconnectQuit, reason)
+	}
+	c.sendPriority(PriorityControl, irc.QUIT+" :"+reason)
+}
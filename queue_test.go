@@ -0,0 +1,154 @@
+package dumbirc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPriorityOf(t *testing.T) {
+	for line, p := range map[string]Priority{
+		"PONG :example.com":          PriorityControl,
+		"QUIT :bye":                  PriorityControl,
+		"nick newnick":               PriorityControl,
+		"MODE #test +o ugjka":        PriorityModeration,
+		"KICK #test spammer":         PriorityModeration,
+		"PRIVMSG #test :hi":          PriorityNormal,
+		"@+typing=active TAGMSG #ch": PriorityNormal,
+	} {
+		if got := priorityOf(line); got != p {
+			t.Errorf("%s: expected %v, got %v", line, p, got)
+		}
+	}
+}
+
+func TestOutQueue(t *testing.T) {
+	q := newOutQueue()
+	q.push(PriorityBulk, "", "bulk")
+	q.push(PriorityNormal, "", "normal1", "normal2")
+	q.push(PriorityControl, "", "control")
+	q.push(PriorityModeration, "", "mode")
+	for _, expected := range []string{"control", "mode", "normal1", "normal2", "bulk"} {
+		if line, ok := q.pop(); !ok || line.line != expected {
+			t.Errorf("expected %s, got %s", expected, line.line)
+		}
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("expected an empty queue")
+	}
+}
+
+func TestFairQueue(t *testing.T) {
+	q := newOutQueue()
+	q.push(PriorityNormal, "#a", "a1", "a2", "a3")
+	q.push(PriorityNormal, "#b", "b1")
+	q.push(PriorityNormal, "nick", "n1", "n2")
+	q.push(PriorityNormal, "#b", "b2")
+	for _, expected := range []string{"a1", "b1", "n1", "a2", "b2", "n2", "a3"} {
+		if line, ok := q.pop(); !ok || line.line != expected {
+			t.Errorf("expected %s, got %s", expected, line.line)
+		}
+	}
+	for line, target := range map[string]string{
+		"PRIVMSG #test :hi":             "#test",
+		"@+typing=active TAGMSG :ugjka": "ugjka",
+		"LUSERS":                        "",
+	} {
+		if got := lineTarget(line); got != target {
+			t.Errorf("%s: expected target %q, got %q", line, target, got)
+		}
+	}
+}
+
+func TestControlJumpsAhead(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(300 * time.Millisecond)
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	bot.Msg("#test", "one")
+	bot.MsgPriority(PriorityBulk, "#test", "announcement")
+	bot.MsgBulk([]string{"#a", "#b"}, "bulk")
+	bot.Msg("#test", "two")
+	srv.decode()
+	start := time.Now()
+	bot.Pong()
+	for _, expected := range []string{"PONG", "two", "announcement", "bulk", "bulk"} {
+		msg, _ := srv.decode()
+		if msg.Command != expected && msg.Trailing() != expected {
+			t.Errorf("expected %s, got %v", expected, msg)
+		}
+		if expected == "PONG" && time.Since(start) > 150*time.Millisecond {
+			t.Error("PONG was throttled")
+		}
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
+
+func TestAtomicBlock(t *testing.T) {
+	q := newOutQueue()
+	block := []outLine{{line: "a1", block: 1}, {line: "a2", block: 1}, {line: "a3", block: 1}}
+	q.pushLines(PriorityNormal, "#a", block, 0)
+	q.push(PriorityNormal, "#b", "b1", "b2")
+	pop := func(expected string) {
+		t.Helper()
+		if line, ok := q.pop(); !ok || line.line != expected {
+			t.Errorf("expected %s, got %s", expected, line.line)
+		}
+	}
+	pop("a1")
+	q.push(PriorityModeration, "#c", "m1")
+	q.push(PriorityControl, "", "PONG")
+	pop("PONG")
+	pop("a2")
+	pop("a3")
+	pop("m1")
+	pop("b1")
+	pop("b2")
+	// cancelling the rest of a block unlocks the lane
+	q.pushLines(PriorityBulk, "#a", []outLine{{line: "a1", block: 2}, {line: "a2", block: 2}}, 0)
+	pop("a1")
+	q.push(PriorityNormal, "#b", "b1")
+	q.remove("#a", false, func(outLine) bool { return true })
+	pop("b1")
+}
+
+func TestMsgAtomic(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			bot.Msg("#test", "chatter")
+		}
+		close(done)
+	}()
+	bot.MsgAtomic("#table", "row1", "row2", "row3")
+	<-done
+	row := 0
+	for i := 0; i < 13; i++ {
+		msg, _ := srv.decode()
+		if msg.Params[0] != "#table" {
+			if row != 0 && row != 3 {
+				t.Errorf("chatter in the middle of the table after row %d", row)
+			}
+			continue
+		}
+		row++
+		if msg.Trailing() != fmt.Sprintf("row%d", row) {
+			t.Errorf("expected row%d, got %v", row, msg)
+		}
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,152 @@
+package dumbirc
+
+import (
+	"strings"
+	"sync"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// RegistrationReason tells why the server refused our registration
+type RegistrationReason int
+
+// Registration failures
+const (
+	//464, the server password is wrong
+	RegBadPassword RegistrationReason = iota
+	//465 or an ERROR about a K-line or similar ban
+	RegBanned
+	//432, the server does not accept our nick
+	RegErroneousNick
+	//433 and HandleNickTaken was not used
+	RegNickInUse
+	//451, the server thinks we skipped registration
+	RegNotRegistered
+	//ERROR, the server closed the link for another reason
+	RegClosed
+)
+
+func (r RegistrationReason) String() string {
+	switch r {
+	case RegBadPassword:
+		return "bad password"
+	case RegBanned:
+		return "banned"
+	case RegErroneousNick:
+		return "erroneous nickname"
+	case RegNickInUse:
+		return "nickname in use"
+	case RegNotRegistered:
+		return "not registered"
+	case RegClosed:
+		return "link closed"
+	}
+	return "unknown"
+}
+
+// RegistrationError is sent to Errchan when the server refuses us before
+// the welcome, the connection is closed afterwards
+type RegistrationError struct {
+	Reason RegistrationReason
+	//The numeric or ERROR
+	Command string
+	//Text from the server
+	Message string
+	//The *ReplyError for numerics
+	Err error
+}
+
+func (e *RegistrationError) Error() string {
+	return "registration failed: " + e.Reason.String() + ": " + e.Message
+}
+
+func (e *RegistrationError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether reconnecting can help, a wrong password,
+// a ban or a bad nick will fail again
+func (e *RegistrationError) Temporary() bool {
+	switch e.Reason {
+	case RegNickInUse, RegNotRegistered, RegClosed:
+		return true
+	}
+	return false
+}
+
+// registration tracks whether the server has welcomed us
+type registration struct {
+	mu         sync.Mutex
+	registered bool
+	err        error
+	//HandleNickTaken deals with 433
+	nickTaken bool
+}
+
+func (r *registration) reset() {
+	r.mu.Lock()
+	r.registered, r.err = false, nil
+	r.mu.Unlock()
+}
+
+// failed returns the registration error, nil if there was none
+func (r *registration) failed() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Registered reports whether the server has welcomed us on this connection
+func (c *Connection) Registered() bool {
+	c.reg.mu.Lock()
+	defer c.reg.mu.Unlock()
+	return c.reg.registered
+}
+
+// isBan reports whether an ERROR text is about a server ban
+func isBan(text string) bool {
+	text = strings.ToLower(text)
+	for _, v := range []string{"k-line", "g-line", "z-line", "d-line", "kline", "gline", "zline", "dline", "banned"} {
+		if strings.Contains(text, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackRegistration fails the connection when the server refuses to register us
+func (c *Connection) trackRegistration() {
+	c.addHandler(WELCOME, func(m *Message) {
+		c.reg.mu.Lock()
+		c.reg.registered = true
+		c.reg.mu.Unlock()
+	})
+	fail := func(reason RegistrationReason) func(*Message) {
+		return func(m *Message) {
+			c.reg.mu.Lock()
+			if c.reg.registered || c.reg.err != nil ||
+				(reason == RegNickInUse && c.reg.nickTaken) {
+				c.reg.mu.Unlock()
+				return
+			}
+			err := &RegistrationError{Reason: reason, Command: m.Command, Message: m.Trailing()}
+			if m.Command == irc.ERROR {
+				if isBan(err.Message) {
+					err.Reason = RegBanned
+				}
+			} else {
+				err.Err = ErrorFromMessage(m)
+			}
+			c.reg.err = err
+			c.reg.mu.Unlock()
+			c.Log.Println(err)
+			c.close()
+		}
+	}
+	c.addHandler(irc.ERR_PASSWDMISMATCH, fail(RegBadPassword))
+	c.addHandler(irc.ERR_YOUREBANNEDCREEP, fail(RegBanned))
+	c.addHandler(irc.ERR_ERRONEUSNICKNAME, fail(RegErroneousNick))
+	c.addHandler(irc.ERR_NICKNAMEINUSE, fail(RegNickInUse))
+	c.addHandler(irc.ERR_NOTREGISTERED, fail(RegNotRegistered))
+	c.addHandler(irc.ERROR, fail(RegClosed))
+}
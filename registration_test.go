@@ -0,0 +1,82 @@
+package dumbirc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRegistrationError(t *testing.T) {
+	tt := []struct {
+		line      string
+		reason    RegistrationReason
+		temporary bool
+		err       error
+	}{
+		{":example.com 464 * :Password incorrect", RegBadPassword, false, nil},
+		{":example.com 465 * :You are banned from this server", RegBanned, false, nil},
+		{":example.com 432 * bad! :Erroneous Nickname", RegErroneousNick, false, ErrErroneusNickname},
+		{":example.com 433 * %s :Nickname is already in use.", RegNickInUse, true, ErrNicknameInUse},
+		{"ERROR :Closing Link: host (K-Lined)", RegBanned, false, nil},
+		{"ERROR :Closing Link: host (Throttled: Reconnecting too fast)", RegClosed, true, nil},
+	}
+	for _, tc := range tt {
+		srv := newServer()
+		bot := New(nick, nick, SERVER, false)
+		bot.SetThrottle(0)
+		bot.Start()
+		for i := 0; i < 2; i++ {
+			srv.decode()
+		}
+		line := tc.line
+		if tc.reason == RegNickInUse {
+			line = fmt.Sprintf(line, nick)
+		}
+		srv.encode(line)
+		var err error
+		select {
+		case err = <-bot.Errchan:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: no error", line)
+		}
+		var re *RegistrationError
+		if !errors.As(err, &re) {
+			t.Errorf("%s: expected *RegistrationError, got %v", line, err)
+		} else if re.Reason != tc.reason || re.Temporary() != tc.temporary {
+			t.Errorf("%s: expected %v temporary %v, got %v temporary %v",
+				line, tc.reason, tc.temporary, re.Reason, re.Temporary())
+		}
+		if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", line, tc.err, err)
+		}
+		if bot.IsConnected() {
+			t.Errorf("%s: expected disconnect", line)
+		}
+		Destroy(bot)
+		srv.stop()
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	srv.encode(fmt.Sprintf(":example.com 001 %s :Welcome", nick))
+	srv.encode(fmt.Sprintf(":example.com 433 %s other :Nickname is already in use.", nick))
+	syncBot(srv, done)
+	if !bot.Registered() || !bot.IsConnected() {
+		t.Errorf("expected to stay registered after a late 433")
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
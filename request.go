@@ -0,0 +1,112 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// ErrNotConnected is returned when a request needs a connection and there is none
+var ErrNotConnected = errors.New("not connected")
+
+// request sends cmd and feeds every received message to handle until it
+// reports done. We subscribe before sending so no reply can be missed,
+// each request has its own subscription so they can run concurrently
+func (c *Connection) request(ctx context.Context, cmd string, handle func(*Message) (done bool, err error)) error {
+	return c.requestLines(ctx, []string{cmd}, handle)
+}
+
+// requestFenced is for commands without an end reply, it follows cmd with
+// a PING and ends once the matching PONG shows all the replies have arrived
+func (c *Connection) requestFenced(ctx context.Context, cmd string, handle func(*Message)) error {
+	token := "fence" + strconv.FormatUint(uint64(atomic.AddUint32(&c.fences, 1)), 10)
+	return c.requestLines(ctx, []string{cmd, irc.PING + " :" + token}, func(m *Message) (bool, error) {
+		if m.Command == PONG && m.Trailing() == token {
+			return true, nil
+		}
+		handle(m)
+		return false, nil
+	})
+}
+
+func (c *Connection) requestLines(ctx context.Context, cmds []string, handle func(*Message) (done bool, err error)) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	client, err := c.messenger.Sub()
+	if err != nil {
+		return ErrNotConnected
+	}
+	defer c.messenger.Unsub(client)
+	// queued together so a fence can't overtake its command
+	if _, err := c.enqueue(priorityOf(cmds[0]), 0, false, cmds); err != nil {
+		return err
+	}
+	for {
+		select {
+		case mes, ok := <-client:
+			if !ok {
+				return ErrNotConnected
+			}
+			done, err := handle(mes.(*Message))
+			if done || err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// CmdWait sends a raw command and waits for its outcome. It returns nil once a
+// reply with one of the success commands mentions target, and a *ReplyError
+// when the server refuses the command for target. E.g.
+//
+//	err := c.CmdWait(ctx, "JOIN #chan", "#chan", JOIN)
+//	if errors.Is(err, ErrBannedFromChan) { ... }
+func (c *Connection) CmdWait(ctx context.Context, cmd, target string, success ...string) error {
+	verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+	return c.request(ctx, cmd, func(m *Message) (bool, error) {
+		if err := ErrorFromMessage(m); err != nil {
+			e := err.(*ReplyError)
+			// unknown command and missing params errors name the command
+			if c.EqualFold(e.Target, target) || strings.EqualFold(e.Target, verb) {
+				return true, err
+			}
+			return false, nil
+		}
+		for _, v := range success {
+			if m.Command != v {
+				continue
+			}
+			for _, p := range m.Params {
+				if c.EqualFold(p, target) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	})
+}
+
+// JoinWait joins a channel and waits until we are in it, errors like
+// ErrBannedFromChan or ErrBadChannelKey are returned as a *ReplyError
+func (c *Connection) JoinWait(ctx context.Context, channel string, key ...string) error {
+	cmd := irc.JOIN + " " + channel
+	if len(key) > 0 && key[0] != "" {
+		cmd += " " + key[0]
+	}
+	return c.request(ctx, cmd, func(m *Message) (bool, error) {
+		if m.Command == JOIN {
+			return c.fromMe(m) && c.EqualFold(m.To, channel), nil
+		}
+		if err := ErrorFromMessage(m); err != nil && c.EqualFold(err.(*ReplyError).Target, channel) {
+			return true, err
+		}
+		return false, nil
+	})
+}
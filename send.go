@@ -0,0 +1,182 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// Errors of the Context send functions
+var (
+	ErrQueueFull     = errors.New("send queue is full")
+	ErrLineTooLong   = errors.New("line too long")
+	ErrInvalidTarget = errors.New("invalid target")
+	//The lines were dropped by CancelTarget, FlushQueue, SendGroup.Cancel
+	//or because we left the channel
+	ErrCancelled = errors.New("send cancelled")
+)
+
+// checkTarget rejects nicks and channels the server can't take
+func checkTarget(target string) error {
+	if target == "" || target[0] == ':' || strings.ContainsAny(target, " ,\r\n\x00\a") {
+		return fmt.Errorf("%q: %w", target, ErrInvalidTarget)
+	}
+	return nil
+}
+
+// checkLines rejects lines longer than the server allows, message tags
+// have their own limit and are not counted
+func (c *Connection) checkLines(lines []string) error {
+	max := c.ISupport().LineLen - 2
+	for _, v := range lines {
+		if strings.HasPrefix(v, "@") {
+			if i := strings.IndexByte(v, ' '); i >= 0 {
+				v = v[i+1:]
+			}
+		}
+		if len(v) > max {
+			return fmt.Errorf("%d bytes, max %d: %w", len(v), max, ErrLineTooLong)
+		}
+	}
+	return nil
+}
+
+// sendChecked checks lines and queues them
+func (c *Connection) sendChecked(p Priority, group uint64, atomic bool, lines []string) (*sendWait, error) {
+	if err := c.checkLines(lines); err != nil {
+		return nil, err
+	}
+	return c.enqueue(p, group, atomic, lines)
+}
+
+// sendLogged is sendContext without the wait for the send functions that
+// return nothing, errors of building the lines or queueing them are logged
+func (c *Connection) sendLogged(p Priority, group uint64, lines []string, err error) {
+	if err == nil {
+		_, err = c.sendChecked(p, group, false, lines)
+	}
+	if err != nil {
+		c.Log.Printf("send: %v", err)
+	}
+}
+
+// sendContext queues lines and waits until they are written. When ctx is
+// done first the lines that are still queued are dropped, ErrCancelled
+// is returned when something else dropped them
+func (c *Connection) sendContext(ctx context.Context, p Priority, atomic bool, lines []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	wait, err := c.sendChecked(p, 0, atomic, lines)
+	if err != nil {
+		return err
+	}
+	c.connectedMu.Lock()
+	disconnect := c.disconnect
+	c.connectedMu.Unlock()
+	select {
+	case <-wait.done:
+		return nil
+	case <-wait.cancelled:
+		return ErrCancelled
+	case <-disconnect:
+		return ErrNotConnected
+	case <-ctx.Done():
+		c.queue.remove("", true, func(v outLine) bool {
+			return v.wait == wait
+		})
+		select {
+		case <-wait.done:
+			return nil
+		default:
+		}
+		return ctx.Err()
+	}
+}
+
+// CmdContext sends a raw command and waits until it is written
+func (c *Connection) CmdContext(ctx context.Context, command string) error {
+	return c.sendContext(ctx, priorityOf(command), false, []string{command})
+}
+
+// MsgContext sends a privmessage and waits until it is written
+func (c *Connection) MsgContext(ctx context.Context, dest, msg string) error {
+	lines, err := c.msgLines(irc.PRIVMSG, dest, msg)
+	if err != nil {
+		return err
+	}
+	return c.sendContext(ctx, PriorityNormal, false, lines)
+}
+
+// NoticeContext sends a notice and waits until it is written
+func (c *Connection) NoticeContext(ctx context.Context, dest, msg string) error {
+	lines, err := c.msgLines(irc.NOTICE, dest, msg)
+	if err != nil {
+		return err
+	}
+	return c.sendContext(ctx, PriorityNormal, false, lines)
+}
+
+// msgLines checks the target and splits a privmessage or notice
+func (c *Connection) msgLines(command, dest, msg string) ([]string, error) {
+	if err := checkTarget(dest); err != nil {
+		return nil, err
+	}
+	return c.split(command, dest, msg), nil
+}
+
+// ActionContext sends an action and waits until it is written
+func (c *Connection) ActionContext(ctx context.Context, dest, msg string) error {
+	return c.MsgContext(ctx, dest, fmt.Sprintf("\u0001ACTION %s\u0001", msg))
+}
+
+// JoinContext joins channels and waits until the JOINs are written,
+// see JoinWait to wait for the server to let us in
+func (c *Connection) JoinContext(ctx context.Context, channels ...string) error {
+	lines, err := c.joinLines(channels)
+	if err != nil {
+		return err
+	}
+	return c.sendContext(ctx, PriorityNormal, false, lines)
+}
+
+func (c *Connection) joinLines(channels []string) ([]string, error) {
+	is := c.ISupport()
+	lines := make([]string, 0, len(channels))
+	for _, v := range channels {
+		if err := checkTarget(v); err != nil {
+			return nil, err
+		}
+		if !is.IsChannel(v) {
+			return nil, fmt.Errorf("%q is not a channel: %w", v, ErrInvalidTarget)
+		}
+		lines = append(lines, irc.JOIN+" "+v)
+	}
+	return lines, nil
+}
+
+// TopicContext sets a channel's topic and waits until it is written
+func (c *Connection) TopicContext(ctx context.Context, channel, topic string) error {
+	lines, err := c.topicLines(channel, topic)
+	if err != nil {
+		return err
+	}
+	return c.sendContext(ctx, PriorityNormal, false, lines)
+}
+
+func (c *Connection) topicLines(channel, topic string) ([]string, error) {
+	if err := checkTarget(channel); err != nil {
+		return nil, err
+	}
+	is := c.ISupport()
+	if !is.IsChannel(channel) {
+		return nil, fmt.Errorf("%q is not a channel: %w", channel, ErrInvalidTarget)
+	}
+	if max := is.TopicLen; max > 0 && len(topic) > max {
+		topic = topic[:max]
+	}
+	return []string{irc.TOPIC + " " + channel + " :" + topic}, nil
+}
@@ -0,0 +1,116 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendContext(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	if err := bot.MsgContext(ctx, "#test", "hi"); err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	if err := bot.MsgContext(ctx, "#test", "hello"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if msg, _ := srv.decode(); msg.Trailing() != "hello" {
+		t.Errorf("expected hello, got %v", msg)
+	}
+	for _, dest := range []string{"", "#a b", "a,b", ":nick"} {
+		if err := bot.MsgContext(ctx, dest, "hi"); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("%q: expected ErrInvalidTarget, got %v", dest, err)
+		}
+	}
+	// the plain send functions check the same way and log the error
+	bot.Msg("#a b", "dropped")
+	bot.Join([]string{"notachannel"})
+	bot.Msg("#test", "after")
+	if msg, _ := srv.decode(); msg.Trailing() != "after" {
+		t.Errorf("expected only the valid message, got %v", msg)
+	}
+	if err := bot.JoinContext(ctx, "#ok", "notachannel"); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("expected ErrInvalidTarget, got %v", err)
+	}
+	if err := bot.CmdContext(ctx, "PRIVMSG #test :"+strings.Repeat("x", 510)); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("expected ErrLineTooLong, got %v", err)
+	}
+	bot.SetThrottle(time.Second)
+	bot.MaxQueue = 2
+	bot.Msg("#test", "sent at once")
+	srv.decode()
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := bot.NoticeContext(timeout, "#test", "too slow"); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if n := bot.QueueLen(); n != 0 {
+		t.Errorf("expected the cancelled line to be dropped, %d queued", n)
+	}
+	bot.Msg("#test", "one")
+	bot.Msg("#test", "two")
+	if err := bot.CmdContext(ctx, "LIST"); err != ErrQueueFull {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	if err := bot.CmdContext(ctx, "PONG"); err != nil {
+		t.Errorf("expected control lines to bypass the limit, got %v", err)
+	}
+	errs := make(chan error)
+	go func() {
+		errs <- bot.CmdContext(ctx, "PONG")
+	}()
+	bot.Disconnect()
+	if err := <-errs; err != nil && err != ErrNotConnected {
+		t.Errorf("expected nil or ErrNotConnected, got %v", err)
+	}
+	Destroy(bot)
+	srv.stop()
+}
+
+func TestSendContextCancelled(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(time.Second)
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	for _, drop := range []func() int{
+		func() int { return bot.CancelTarget("#A") },
+		bot.FlushQueue,
+	} {
+		// the next line waits for the throttle
+		bot.Msg("#b", "sent")
+		srv.decode()
+		errs := make(chan error)
+		go func() {
+			errs <- bot.MsgContext(ctx, "#a", "dropped")
+		}()
+		for bot.QueueLen() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if n := drop(); n != 1 {
+			t.Errorf("expected 1 dropped line, got %d", n)
+		}
+		select {
+		case err := <-errs:
+			if err != ErrCancelled {
+				t.Errorf("expected ErrCancelled, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("MsgContext still waiting after its lines were dropped")
+		}
+	}
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,295 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// ErrNoMOTD is returned when the server has no MOTD
+var ErrNoMOTD = errors.New("server has no MOTD")
+
+// Lusers holds the user and server counts of a LUSERS reply
+type Lusers struct {
+	Users          int
+	Invisible      int
+	Servers        int
+	Operators      int
+	Unknown        int
+	Channels       int
+	LocalClients   int
+	LocalServers   int
+	LocalUsers     int
+	MaxLocalUsers  int
+	GlobalUsers    int
+	MaxGlobalUsers int
+}
+
+// ServerVersion is a VERSION reply
+type ServerVersion struct {
+	Version  string
+	Server   string
+	Comments string
+}
+
+// ServerTime is a TIME reply, the time is as the server formatted it
+type ServerTime struct {
+	Server string
+	Time   string
+}
+
+// AdminInfo is an ADMIN reply
+type AdminInfo struct {
+	Server    string
+	Location1 string
+	Location2 string
+	Email     string
+}
+
+// numbers returns the integers in s, "There are 5 users and 2 invisible" gives [5 2]
+func numbers(s string) (n []int) {
+	for _, v := range strings.FieldsFunc(s, func(r rune) bool { return r < '0' || r > '9' }) {
+		if i, err := strconv.Atoi(v); err == nil {
+			n = append(n, i)
+		}
+	}
+	return n
+}
+
+// update fills in the counts from a LUSERS numeric
+func (l *Lusers) update(m *Message) {
+	param := func() int {
+		if len(m.Params) < 3 {
+			return 0
+		}
+		n, _ := strconv.Atoi(m.Params[1])
+		return n
+	}
+	// 265 and 266 may have the counts as params or only in the text
+	pair := func(cur, max *int) {
+		n := numbers(m.Trailing())
+		if len(m.Params) > 3 {
+			n = numbers(m.Params[1] + " " + m.Params[2])
+		}
+		if len(n) > 1 {
+			*cur, *max = n[0], n[1]
+		}
+	}
+	switch m.Command {
+	case irc.RPL_LUSERCLIENT:
+		// "There are 5 users and 10 invisible on 3 servers"
+		if n := numbers(m.Trailing()); len(n) > 2 {
+			l.Users, l.Invisible, l.Servers = n[0], n[1], n[2]
+		}
+	case irc.RPL_LUSEROP:
+		l.Operators = param()
+	case irc.RPL_LUSERUNKNOWN:
+		l.Unknown = param()
+	case irc.RPL_LUSERCHANNELS:
+		l.Channels = param()
+	case irc.RPL_LUSERME:
+		// "I have 12 clients and 1 servers"
+		if n := numbers(m.Trailing()); len(n) > 1 {
+			l.LocalClients, l.LocalServers = n[0], n[1]
+		}
+	case RPL_LOCALUSERS:
+		pair(&l.LocalUsers, &l.MaxLocalUsers)
+	case RPL_GLOBALUSERS:
+		pair(&l.GlobalUsers, &l.MaxGlobalUsers)
+	}
+}
+
+// serverInfo is what the server told us on connect
+type serverInfo struct {
+	mu     sync.Mutex
+	motd   []string
+	lines  []string
+	lusers Lusers
+}
+
+func (s *serverInfo) reset() {
+	s.mu.Lock()
+	s.motd, s.lines, s.lusers = nil, nil, Lusers{}
+	s.mu.Unlock()
+}
+
+// ServerMOTD returns the last MOTD the server sent, the one from connecting
+// unless MOTD was requested since
+func (c *Connection) ServerMOTD() string {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	return strings.Join(c.server.motd, "\n")
+}
+
+// ServerLusers returns the last LUSERS counts the server sent
+func (c *Connection) ServerLusers() Lusers {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	return c.server.lusers
+}
+
+// trackServerInfo keeps the MOTD and LUSERS the server sends
+func (c *Connection) trackServerInfo() {
+	c.addHandler(irc.RPL_MOTDSTART, func(m *Message) {
+		c.server.mu.Lock()
+		c.server.lines = nil
+		c.server.mu.Unlock()
+	})
+	c.addHandler(irc.RPL_MOTD, func(m *Message) {
+		c.server.mu.Lock()
+		c.server.lines = append(c.server.lines, motdLine(m))
+		c.server.mu.Unlock()
+	})
+	c.addHandler(ENDOFMOTD, func(m *Message) {
+		c.server.mu.Lock()
+		c.server.motd, c.server.lines = c.server.lines, nil
+		c.server.mu.Unlock()
+	})
+	c.addHandler(NOMOTD, func(m *Message) {
+		c.server.mu.Lock()
+		c.server.motd, c.server.lines = nil, nil
+		c.server.mu.Unlock()
+	})
+	for _, v := range []string{irc.RPL_LUSERCLIENT, irc.RPL_LUSEROP, irc.RPL_LUSERUNKNOWN,
+		irc.RPL_LUSERCHANNELS, irc.RPL_LUSERME, RPL_LOCALUSERS, RPL_GLOBALUSERS} {
+		c.addHandler(v, func(m *Message) {
+			c.server.mu.Lock()
+			c.server.lusers.update(m)
+			c.server.mu.Unlock()
+		})
+	}
+}
+
+// motdLine strips the "- " servers put in front of MOTD lines
+func motdLine(m *Message) string {
+	line := m.Trailing()
+	if strings.HasPrefix(line, "- ") {
+		return line[2:]
+	}
+	return strings.TrimPrefix(line, "-")
+}
+
+// MOTD requests the message of the day, returns ErrNoMOTD if there is none
+func (c *Connection) MOTD(ctx context.Context) (string, error) {
+	var lines []string
+	err := c.request(ctx, irc.MOTD, func(m *Message) (bool, error) {
+		switch m.Command {
+		case irc.RPL_MOTD:
+			lines = append(lines, motdLine(m))
+		case ENDOFMOTD:
+			return true, nil
+		case NOMOTD:
+			return true, ErrNoMOTD
+		default:
+			if isErrorNumeric(m.Command) {
+				return true, ErrorFromMessage(m)
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Lusers requests the user and server counts
+func (c *Connection) Lusers(ctx context.Context) (*Lusers, error) {
+	l := &Lusers{}
+	err := c.requestFenced(ctx, irc.LUSERS, func(m *Message) {
+		l.update(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Version requests the server's version
+func (c *Connection) Version(ctx context.Context) (*ServerVersion, error) {
+	v := &ServerVersion{}
+	err := c.request(ctx, irc.VERSION, func(m *Message) (bool, error) {
+		if isErrorNumeric(m.Command) {
+			return true, ErrorFromMessage(m)
+		}
+		// "351 me version server :comments"
+		if m.Command != irc.RPL_VERSION || len(m.Params) < 3 {
+			return false, nil
+		}
+		v.Version, v.Server = m.Params[1], m.Params[2]
+		if len(m.Params) > 3 {
+			v.Comments = m.Trailing()
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Time requests the server's local time
+func (c *Connection) Time(ctx context.Context) (*ServerTime, error) {
+	t := &ServerTime{}
+	err := c.request(ctx, irc.TIME, func(m *Message) (bool, error) {
+		if isErrorNumeric(m.Command) {
+			return true, ErrorFromMessage(m)
+		}
+		// "391 me server :Saturday January 12 2019 -- 12:00:00 +00:00"
+		if m.Command != irc.RPL_TIME || len(m.Params) < 3 {
+			return false, nil
+		}
+		t.Server, t.Time = m.Params[1], m.Trailing()
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Admin requests the server's administrative contacts
+func (c *Connection) Admin(ctx context.Context) (*AdminInfo, error) {
+	a := &AdminInfo{}
+	err := c.requestFenced(ctx, irc.ADMIN, func(m *Message) {
+		switch m.Command {
+		case irc.RPL_ADMINME:
+			if len(m.Params) > 2 {
+				a.Server = m.Params[1]
+			}
+		case irc.RPL_ADMINLOC1:
+			a.Location1 = m.Trailing()
+		case irc.RPL_ADMINLOC2:
+			a.Location2 = m.Trailing()
+		case irc.RPL_ADMINEMAIL:
+			a.Email = m.Trailing()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Info requests the server's INFO text
+func (c *Connection) Info(ctx context.Context) ([]string, error) {
+	var lines []string
+	err := c.request(ctx, irc.INFO, func(m *Message) (bool, error) {
+		switch m.Command {
+		case irc.RPL_INFO:
+			lines = append(lines, m.Trailing())
+		case irc.RPL_ENDOFINFO:
+			return true, nil
+		default:
+			if isErrorNumeric(m.Command) {
+				return true, ErrorFromMessage(m)
+			}
+		}
+		return false, nil
+	})
+	return lines, err
+}
@@ -0,0 +1,137 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestServerInfo(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	for _, v := range []string{
+		":example.com 251 %s :There are 5 users and 10 invisible on 3 servers",
+		":example.com 252 %s 2 :IRC Operators online",
+		":example.com 254 %s 7 :channels formed",
+		":example.com 255 %s :I have 12 clients and 1 servers",
+		":example.com 265 %s 12 20 :Current local users 12, max 20",
+		":example.com 266 %s :Current global users: 15  Max: 30",
+		":example.com 375 %s :- example.com Message of the Day -",
+		":example.com 372 %s :- hello",
+		":example.com 372 %s :- world",
+		":example.com 376 %s :End of /MOTD command.",
+	} {
+		srv.encode(fmt.Sprintf(v, nick))
+	}
+	syncBot(srv, done)
+	if motd := bot.ServerMOTD(); motd != "hello\nworld" {
+		t.Errorf("expected connect MOTD %q, got %q", "hello\nworld", motd)
+	}
+	expected := Lusers{
+		Users: 5, Invisible: 10, Servers: 3, Operators: 2, Channels: 7,
+		LocalClients: 12, LocalServers: 1, LocalUsers: 12, MaxLocalUsers: 20,
+		GlobalUsers: 15, MaxGlobalUsers: 30,
+	}
+	if l := bot.ServerLusers(); l != expected {
+		t.Errorf("expected %+v, got %+v", expected, l)
+	}
+	results := make(chan interface{})
+	go func() {
+		l, err := bot.Lusers(context.Background())
+		if err != nil {
+			results <- err
+			return
+		}
+		results <- *l
+	}()
+	srv.decode()
+	ping, _ := srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 251 %s :There are 1 users and 0 invisible on 1 servers", nick))
+	srv.encode(fmt.Sprintf(":example.com PONG example.com :%s", ping.Trailing()))
+	if r := <-results; r != (Lusers{Users: 1, Servers: 1}) {
+		t.Errorf("expected 1 user on 1 server, got %+v", r)
+	}
+	go func() {
+		_, err := bot.MOTD(context.Background())
+		results <- err
+	}()
+	srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 422 %s :MOTD File is missing", nick))
+	if r := <-results; r != ErrNoMOTD {
+		t.Errorf("expected ErrNoMOTD, got %v", r)
+	}
+	go func() {
+		v, _ := bot.Version(context.Background())
+		results <- *v
+	}()
+	srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 351 %s ircd-1.0 example.com :TS6", nick))
+	if r := <-results; r != (ServerVersion{"ircd-1.0", "example.com", "TS6"}) {
+		t.Errorf("unexpected version %+v", r)
+	}
+	go func() {
+		v, _ := bot.Time(context.Background())
+		results <- *v
+	}()
+	srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 391 %s example.com :Saturday January 12 2019 -- 12:00:00 +00:00", nick))
+	if r := <-results; r != (ServerTime{"example.com", "Saturday January 12 2019 -- 12:00:00 +00:00"}) {
+		t.Errorf("unexpected time %+v", r)
+	}
+	go func() {
+		v, _ := bot.Admin(context.Background())
+		results <- *v
+	}()
+	srv.decode()
+	ping, _ = srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 256 %s example.com :Administrative info", nick))
+	srv.encode(fmt.Sprintf(":example.com 257 %s :Somewhere", nick))
+	srv.encode(fmt.Sprintf(":example.com 259 %s :admin@example.com", nick))
+	srv.encode(fmt.Sprintf(":example.com PONG example.com :%s", ping.Trailing()))
+	if r := <-results; r != (AdminInfo{Server: "example.com", Location1: "Somewhere", Email: "admin@example.com"}) {
+		t.Errorf("unexpected admin info %+v", r)
+	}
+	go func() {
+		v, _ := bot.Info(context.Background())
+		results <- v
+	}()
+	srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 371 %s :line one", nick))
+	srv.encode(fmt.Sprintf(":example.com 371 %s :line two", nick))
+	srv.encode(fmt.Sprintf(":example.com 374 %s :End of /INFO list", nick))
+	if r := <-results; !reflect.DeepEqual(r, []string{"line one", "line two"}) {
+		t.Errorf("unexpected info %v", r)
+	}
+	go func() {
+		_, err := bot.Version(context.Background())
+		results <- err
+	}()
+	srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 402 %s bogus.server :No such server", nick))
+	if r := <-results; !errors.Is(r.(error), ErrNoSuchServer) {
+		t.Errorf("expected ErrNoSuchServer, got %v", r)
+	}
+	go func() {
+		_, err := bot.Info(context.Background())
+		results <- err
+	}()
+	srv.decode()
+	srv.encode(fmt.Sprintf(":example.com 481 %s :Permission Denied", nick))
+	if r := <-results; !errors.Is(r.(error), ErrNoPrivileges) {
+		t.Errorf("expected ErrNoPrivileges, got %v", r)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,352 @@
+package dumbirc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ugjka/dumbirc/modes"
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// Channel is a snapshot of a channel we are in
+type Channel struct {
+	Name string
+	//Members keyed by casefolded nick
+	Members map[string]Member
+	//Channel modes with their parameters, list modes (bans etc) are not tracked
+	Modes      map[byte]string
+	Topic      string
+	TopicSetBy string
+	TopicTime  time.Time
+}
+
+// Member of a channel
+type Member struct {
+	Nick string
+	//Status prefixes, highest first e.g. "@+"
+	Prefixes string
+}
+
+type trackedChannel struct {
+	Channel
+	//a NAMES reply is complete, the next one starts a new list
+	namesDone bool
+}
+
+// channelState holds the channels and users we know about
+type channelState struct {
+	mu       sync.RWMutex
+	channels map[string]*trackedChannel
+	users    map[string]*trackedUser
+	//bots we know of from WHO but share no channel with, e.g. ones
+	//that only message us
+	whoBots map[string]bool
+}
+
+// most whoBots remembered, it starts over when full
+const maxWhoBots = 1000
+
+func newChannelState() *channelState {
+	s := &channelState{}
+	s.reset()
+	return s
+}
+
+func (s *channelState) reset() {
+	s.mu.Lock()
+	s.channels = make(map[string]*trackedChannel)
+	s.users = make(map[string]*trackedUser)
+	s.whoBots = make(map[string]bool)
+	s.mu.Unlock()
+}
+
+func (ch *trackedChannel) copy() *Channel {
+	cp := ch.Channel
+	cp.Members = make(map[string]Member, len(ch.Members))
+	for k, v := range ch.Members {
+		cp.Members[k] = v
+	}
+	cp.Modes = make(map[byte]string, len(ch.Modes))
+	for k, v := range ch.Modes {
+		cp.Modes[k] = v
+	}
+	return &cp
+}
+
+// Channels returns the names of the channels we are in
+func (c *Connection) Channels() []string {
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	names := make([]string, 0, len(c.state.channels))
+	for _, ch := range c.state.channels {
+		names = append(names, ch.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Channel returns a snapshot of a channel we are in, nil if we are not in it
+func (c *Connection) Channel(name string) *Channel {
+	key := c.Fold(name)
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	ch, ok := c.state.channels[key]
+	if !ok {
+		return nil
+	}
+	return ch.copy()
+}
+
+// setPrefix adds or removes a status symbol keeping them ordered by rank
+func setPrefix(is *ISupport, prefixes string, symbol byte, add bool) string {
+	out := ""
+	for i := 0; i < len(is.PrefixSymbols); i++ {
+		s := is.PrefixSymbols[i]
+		if s == symbol {
+			if add {
+				out += string(s)
+			}
+			continue
+		}
+		if strings.IndexByte(prefixes, s) >= 0 {
+			out += string(s)
+		}
+	}
+	return out
+}
+
+// splitPrefixes splits "@+nick" into the status symbols and the rest
+func splitPrefixes(is *ISupport, name string) (prefixes, rest string) {
+	i := 0
+	for i < len(name) && strings.IndexByte(is.PrefixSymbols, name[i]) >= 0 {
+		i++
+	}
+	return name[:i], name[i:]
+}
+
+// trackChannels maintains the state of the channels we are in
+func (c *Connection) trackChannels() {
+	c.addHandler(JOIN, func(m *Message) {
+		if m.Prefix == nil || m.To == "" {
+			return
+		}
+		is := c.ISupport()
+		key, nick := CaseFold(is.CaseMapping, m.To), CaseFold(is.CaseMapping, m.Name)
+		me := c.fromMe(m)
+		c.state.mu.Lock()
+		defer c.state.mu.Unlock()
+		if me {
+			c.state.channels[key] = &trackedChannel{
+				Channel: Channel{
+					Name:    m.To,
+					Members: map[string]Member{nick: {Nick: m.Name}},
+					Modes:   make(map[byte]string),
+				},
+				namesDone: true,
+			}
+			if c.SyncChannels {
+				go c.send("MODE " + m.To)
+			}
+			return
+		}
+		if ch, ok := c.state.channels[key]; ok {
+			ch.Members[nick] = Member{Nick: m.Name}
+		}
+	})
+	c.addHandler(irc.PART, func(m *Message) {
+		if m.Prefix == nil || len(m.Params) == 0 {
+			return
+		}
+		c.removeMember(m.Params[0], m.Name, c.fromMe(m))
+	})
+	c.addHandler(KICK, func(m *Message) {
+		if len(m.Params) < 2 {
+			return
+		}
+		c.removeMember(m.Params[0], m.Params[1], c.EqualFold(m.Params[1], c.CurrentNick()))
+	})
+	c.addHandler(irc.QUIT, func(m *Message) {
+		if m.Prefix == nil {
+			return
+		}
+		nick := c.Fold(m.Name)
+		c.state.mu.Lock()
+		defer c.state.mu.Unlock()
+		for _, ch := range c.state.channels {
+			delete(ch.Members, nick)
+		}
+	})
+	c.addHandler(irc.NICK, func(m *Message) {
+		if m.Prefix == nil || len(m.Params) == 0 {
+			return
+		}
+		oldNick, newNick := c.Fold(m.Name), c.Fold(m.Params[0])
+		c.state.mu.Lock()
+		defer c.state.mu.Unlock()
+		for _, ch := range c.state.channels {
+			if member, ok := ch.Members[oldNick]; ok {
+				delete(ch.Members, oldNick)
+				member.Nick = m.Params[0]
+				ch.Members[newNick] = member
+			}
+		}
+	})
+	c.addHandler(irc.MODE, func(m *Message) {
+		if len(m.Params) < 2 {
+			return
+		}
+		c.applyModes(m.Params[0], m.Params[1:], false)
+	})
+	c.addHandler(irc.RPL_CHANNELMODEIS, func(m *Message) {
+		// "324 me #chan +ntk key"
+		if len(m.Params) < 3 {
+			return
+		}
+		c.applyModes(m.Params[1], m.Params[2:], true)
+	})
+	c.addHandler(irc.RPL_NAMREPLY, func(m *Message) {
+		// "353 me = #chan :@nick +nick2 nick3"
+		if len(m.Params) < 4 {
+			return
+		}
+		is := c.ISupport()
+		key := CaseFold(is.CaseMapping, m.Params[2])
+		c.state.mu.Lock()
+		defer c.state.mu.Unlock()
+		ch, ok := c.state.channels[key]
+		if !ok {
+			return
+		}
+		if ch.namesDone {
+			ch.Members = make(map[string]Member)
+			ch.namesDone = false
+		}
+		for _, v := range strings.Fields(m.Trailing()) {
+			prefixes, name := splitPrefixes(is, v)
+			// userhost-in-names sends nick!user@host
+			name = irc.ParsePrefix(name).Name
+			ch.Members[CaseFold(is.CaseMapping, name)] = Member{Nick: name, Prefixes: prefixes}
+		}
+	})
+	c.addHandler(irc.RPL_ENDOFNAMES, func(m *Message) {
+		if len(m.Params) < 2 {
+			return
+		}
+		c.withChannel(m.Params[1], func(ch *trackedChannel) {
+			ch.namesDone = true
+		})
+	})
+	c.addHandler(irc.TOPIC, func(m *Message) {
+		if m.Prefix == nil || len(m.Params) < 2 {
+			return
+		}
+		c.withChannel(m.Params[0], func(ch *trackedChannel) {
+			ch.Topic = m.Trailing()
+			ch.TopicSetBy = m.Prefix.String()
+			ch.TopicTime = m.TimeStamp
+		})
+	})
+	c.addHandler(irc.RPL_TOPIC, func(m *Message) {
+		if len(m.Params) < 3 {
+			return
+		}
+		c.withChannel(m.Params[1], func(ch *trackedChannel) {
+			ch.Topic = m.Trailing()
+		})
+	})
+	c.addHandler(irc.RPL_NOTOPIC, func(m *Message) {
+		if len(m.Params) < 2 {
+			return
+		}
+		c.withChannel(m.Params[1], func(ch *trackedChannel) {
+			ch.Topic, ch.TopicSetBy, ch.TopicTime = "", "", time.Time{}
+		})
+	})
+	c.addHandler(irc.RPL_TOPICWHOTIME, func(m *Message) {
+		// "333 me #chan setter 1547000000"
+		if len(m.Params) < 4 {
+			return
+		}
+		c.withChannel(m.Params[1], func(ch *trackedChannel) {
+			ch.TopicSetBy = m.Params[2]
+			if sec, err := strconv.ParseInt(m.Params[3], 10, 64); err == nil {
+				ch.TopicTime = time.Unix(sec, 0)
+			}
+		})
+	})
+}
+
+// withChannel runs f on a channel we are in while holding the state lock
+func (c *Connection) withChannel(name string, f func(*trackedChannel)) {
+	key := c.Fold(name)
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	if ch, ok := c.state.channels[key]; ok {
+		f(ch)
+	}
+}
+
+func (c *Connection) removeMember(channel, nick string, me bool) {
+	key, nick := c.Fold(channel), c.Fold(nick)
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	if me {
+		delete(c.state.channels, key)
+		return
+	}
+	if ch, ok := c.state.channels[key]; ok {
+		delete(ch.Members, nick)
+	}
+}
+
+// applyModes applies channel mode changes, reset replaces the known modes (324)
+func (c *Connection) applyModes(target string, params []string, reset bool) {
+	is := c.ISupport()
+	if !is.IsChannel(target) || len(params) == 0 {
+		return
+	}
+	changes := is.ModeConfig().Parse(params[0], params[1:]...)
+	c.withChannel(target, func(ch *trackedChannel) {
+		if reset {
+			ch.Modes = make(map[byte]string)
+		}
+		for _, v := range changes {
+			switch v.Type {
+			case modes.Prefix:
+				key := CaseFold(is.CaseMapping, v.Arg)
+				if member, ok := ch.Members[key]; ok {
+					symbol := is.PrefixSymbols[strings.IndexByte(is.PrefixModes, v.Mode)]
+					member.Prefixes = setPrefix(is, member.Prefixes, symbol, v.Add)
+					ch.Members[key] = member
+				}
+			case modes.List:
+				// bans and other lists are not tracked
+			default:
+				if v.Add {
+					ch.Modes[v.Mode] = v.Arg
+				} else {
+					delete(ch.Modes, v.Mode)
+				}
+			}
+		}
+	})
+}
+
+// ParseModes parses a mode string and its parameters with the server's modes
+func (c *Connection) ParseModes(modestring string, args ...string) []modes.Change {
+	return c.ISupport().ModeConfig().Parse(modestring, args...)
+}
+
+// SetModes applies mode changes to a channel in as few MODE commands
+// as the server's MODES limit allows, e.g. voicing many users at once
+func (c *Connection) SetModes(channel string, changes []modes.Change) {
+	is := c.ISupport()
+	cmd := irc.MODE + " " + channel + " "
+	maxLen := is.LineLen - 2 - (2 + c.prefixlenGet() + len(cmd))
+	for _, v := range is.ModeConfig().Format(changes, is.Modes, maxLen) {
+		c.send(cmd + v)
+	}
+}
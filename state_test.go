@@ -0,0 +1,114 @@
+package dumbirc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ugjka/dumbirc/modes"
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// syncBot waits until the bot has handled everything the server sent so far
+func syncBot(srv *ircServer, done chan struct{}) {
+	srv.encode(":example.com TESTSYNC")
+	<-done
+}
+
+func TestTrackChannels(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	bot.Start()
+	srv.encode(fmt.Sprintf(":example.com 005 %s PREFIX=(qov)~@+ CHANMODES=b,k,l,imnt :are supported", nick))
+	srv.encode(fmt.Sprintf(":%s!%s@example.com JOIN #Test", nick, nick))
+	srv.encode(fmt.Sprintf(":example.com 332 %s #test :old topic", nick))
+	srv.encode(fmt.Sprintf(":example.com 333 %s #test setter!s@example.com 1547000000", nick))
+	srv.encode(fmt.Sprintf(":example.com 353 %s = #test :~@%s +voiced other!o@example.com", nick, nick))
+	srv.encode(fmt.Sprintf(":example.com 366 %s #test :End of /NAMES list.", nick))
+	srv.encode(fmt.Sprintf(":example.com 324 %s #test +ntl 10", nick))
+	srv.encode(":joiner!j@example.com JOIN #test")
+	srv.encode(":op!o@example.com MODE #test +o-v+k joiner voiced secret")
+	srv.encode(":op!o@example.com MODE #test -l+b *!*@bad")
+	srv.encode(":other!o@example.com NICK renamed")
+	srv.encode(":op!o@example.com KICK #test joiner :bye")
+	syncBot(srv, done)
+	if chans := bot.Channels(); !reflect.DeepEqual(chans, []string{"#Test"}) {
+		t.Errorf("expected channels [#Test], got %v", chans)
+	}
+	ch := bot.Channel("#TEST")
+	if ch == nil {
+		t.Fatal("expected #test state")
+	}
+	expected := map[string]Member{
+		nick:      {Nick: nick, Prefixes: "~@"},
+		"voiced":  {Nick: "voiced"},
+		"renamed": {Nick: "renamed"},
+	}
+	if !reflect.DeepEqual(ch.Members, expected) {
+		t.Errorf("expected members %v, got %v", expected, ch.Members)
+	}
+	if !reflect.DeepEqual(ch.Modes, map[byte]string{'n': "", 't': "", 'k': "secret"}) {
+		t.Errorf("unexpected modes %v", ch.Modes)
+	}
+	if ch.Topic != "old topic" || ch.TopicSetBy != "setter!s@example.com" || !ch.TopicTime.Equal(time.Unix(1547000000, 0)) {
+		t.Errorf("unexpected topic %q by %q at %v", ch.Topic, ch.TopicSetBy, ch.TopicTime)
+	}
+	srv.encode(":renamed!o@example.com TOPIC #test :new topic")
+	srv.encode(":voiced!v@example.com QUIT :gone")
+	syncBot(srv, done)
+	ch = bot.Channel("#test")
+	if ch.Topic != "new topic" || ch.TopicSetBy != "renamed!o@example.com" {
+		t.Errorf("unexpected topic %q by %q", ch.Topic, ch.TopicSetBy)
+	}
+	if _, ok := ch.Members["voiced"]; ok {
+		t.Error("expected voiced to be gone after quit")
+	}
+	srv.encode(fmt.Sprintf(":%s!%s@example.com PART #test", nick, nick))
+	syncBot(srv, done)
+	if bot.Channel("#test") != nil {
+		t.Error("expected no #test state after part")
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
+
+func TestSetModes(t *testing.T) {
+	tt := []*irc.Message{
+		irc.ParseMessage(fmt.Sprintf("USER %s +iw * %s", nick, nick)),
+		irc.ParseMessage(fmt.Sprintf("NICK %s", nick)),
+		irc.ParseMessage("MODE #test +vvvv a b c d"),
+		irc.ParseMessage("MODE #test +v-o+k e f key"),
+	}
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	bot.Start()
+	srv.encode(fmt.Sprintf(":example.com 005 %s MODES=4 :are supported", nick))
+	syncBot(srv, done)
+	changes := append(modes.Many(true, 'v', "a", "b", "c", "d", "e"), bot.ParseModes("-o+k", "f", "key")...)
+	go bot.SetModes("#test", changes)
+	for _, tc := range tt {
+		msg, err := srv.decode()
+		if err != nil {
+			t.Errorf("decoding a message failed: %v", err)
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(tc, msg) {
+			t.Errorf("expected %v, got %v", tc, msg)
+		}
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,56 @@
+package dumbirc
+
+import "strings"
+
+// splitTags splits "@a=b;c :prefix CMD" into the tags and the rest of the line
+func splitTags(line string) (tags map[string]string, rest string) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line
+	}
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return nil, ""
+	}
+	tags = make(map[string]string)
+	for _, v := range strings.Split(line[1:i], ";") {
+		if v == "" {
+			continue
+		}
+		if j := strings.IndexByte(v, '='); j >= 0 {
+			tags[v[:j]] = unescapeTag(v[j+1:])
+		} else {
+			tags[v] = ""
+		}
+	}
+	return tags, strings.TrimLeft(line[i:], " ")
+}
+
+func unescapeTag(v string) string {
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		// a lone trailing backslash is dropped
+		if i++; i == len(v) {
+			break
+		}
+		switch v[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package dumbirc
+
+import (
+	"sync"
+	"time"
+)
+
+// TypingState of a +typing notification
+type TypingState string
+
+// Typing states
+const (
+	TypingActive TypingState = "active"
+	TypingPaused TypingState = "paused"
+	TypingDone   TypingState = "done"
+)
+
+// the spec allows one active notification per target every 3 seconds
+const typingThrottle = time.Second * 3
+
+type typingState struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// Typing sends a typing notification to 'target' (user or channel),
+// requires the message-tags capability. Repeated active notifications
+// for the same target are throttled
+func (c *Connection) Typing(target string, state TypingState) {
+	if !c.HasCap("message-tags") {
+		return
+	}
+	key := c.Fold(target)
+	c.typing.mu.Lock()
+	if state == TypingActive {
+		if time.Since(c.typing.last[key]) < typingThrottle {
+			c.typing.mu.Unlock()
+			return
+		}
+		c.typing.last[key] = time.Now()
+	} else {
+		delete(c.typing.last, key)
+	}
+	c.typing.mu.Unlock()
+	c.send("@+typing=" + string(state) + " " + TAGMSG + " " + target)
+}
+
+// TypingState returns the state of a typing notification, empty if the message is not one
+func (m *Message) TypingState() TypingState {
+	return TypingState(m.Tags["+typing"])
+}
+
+// handleTyping turns incoming typing notifications into TYPING events
+func (c *Connection) handleTyping() {
+	c.addHandler(TAGMSG, func(m *Message) {
+		if m.TypingState() == "" || c.fromMe(m) {
+			return
+		}
+		c.emit(TYPING, m)
+	})
+}
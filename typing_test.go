@@ -0,0 +1,60 @@
+package dumbirc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTyping(t *testing.T) {
+	tt := []string{
+		"CAP LS 302",
+		fmt.Sprintf("USER %s +iw * :%s", nick, nick),
+		fmt.Sprintf("NICK %s", nick),
+		"CAP REQ :message-tags",
+		"CAP END",
+		"@+typing=active TAGMSG #test",
+		"@+typing=active TAGMSG #test2",
+		"@+typing=done TAGMSG #test",
+		"@+typing=active TAGMSG #test",
+	}
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.Caps = []string{"message-tags"}
+	typing := make(chan *Message)
+	bot.AddCallback(TYPING, func(m *Message) {
+		typing <- m
+	})
+	bot.Start()
+	srv.encode("CAP * LS :message-tags")
+	for i, tc := range tt {
+		line, err := srv.readLine()
+		if err != nil {
+			t.Errorf("reading a line failed: %v", err)
+			t.FailNow()
+		}
+		if line != tc {
+			t.Errorf("expected %q, got %q", tc, line)
+		}
+		if i == 3 {
+			srv.encode("CAP * ACK :message-tags")
+		}
+		if i == 4 {
+			go func() {
+				bot.Typing("#test", TypingActive)
+				bot.Typing("#test", TypingActive)
+				bot.Typing("#test2", TypingActive)
+				bot.Typing("#test", TypingDone)
+				bot.Typing("#test", TypingActive)
+			}()
+		}
+	}
+	srv.raw("@+typing=paused :other!other@example.com TAGMSG #test")
+	m := <-typing
+	if m.TypingState() != TypingPaused || m.To != "#test" {
+		t.Errorf("expected paused typing in #test, got %s in %s", m.TypingState(), m.To)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
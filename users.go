@@ -0,0 +1,337 @@
+package dumbirc
+
+import (
+	"sort"
+	"strings"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// User is a snapshot of what we know about a user
+type User struct {
+	Nick     string
+	User     string
+	Host     string
+	Realname string
+	//Services account, empty when logged out or unknown
+	Account     string
+	Away        bool
+	AwayMessage string
+	Bot         bool
+	//Names of the channels we share with the user
+	Channels []string
+}
+
+type trackedUser struct {
+	User
+	//shared channels, casefolded name to name
+	channels map[string]string
+}
+
+func (u *trackedUser) copy() *User {
+	cp := u.User
+	cp.Channels = make([]string, 0, len(u.channels))
+	for _, v := range u.channels {
+		cp.Channels = append(cp.Channels, v)
+	}
+	sort.Strings(cp.Channels)
+	return &cp
+}
+
+// LookupUser returns what we know about a user, nil if we know nothing.
+// Only users we share a channel with are tracked
+func (c *Connection) LookupUser(nick string) *User {
+	key := c.Fold(nick)
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	u, ok := c.state.users[key]
+	if !ok {
+		return nil
+	}
+	return u.copy()
+}
+
+// user returns the tracked user, creating it, the state lock must be held
+func (c *Connection) user(is *ISupport, nick string) *trackedUser {
+	key := CaseFold(is.CaseMapping, nick)
+	u, ok := c.state.users[key]
+	if !ok {
+		u = &trackedUser{User: User{Nick: nick}, channels: make(map[string]string)}
+		u.Bot = c.state.whoBots[key]
+		delete(c.state.whoBots, key)
+		c.state.users[key] = u
+	}
+	return u
+}
+
+// updateUser runs f on the user under the state lock, creating it if needed
+func (c *Connection) updateUser(nick string, create bool, f func(*trackedUser)) {
+	is := c.ISupport()
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	if _, ok := c.state.users[CaseFold(is.CaseMapping, nick)]; !ok && !create {
+		return
+	}
+	f(c.user(is, nick))
+}
+
+// pruneUsers forgets users we no longer share a channel with, the state lock must be held
+func (c *Connection) pruneUsers(me string) {
+	for k, u := range c.state.users {
+		if len(u.channels) == 0 && k != me {
+			delete(c.state.users, k)
+		}
+	}
+}
+
+// leaveChannel removes a channel from users, from everyone when we left it
+func (c *Connection) leaveChannel(channel, nick string, me bool) {
+	is := c.ISupport()
+	key, nick := CaseFold(is.CaseMapping, channel), CaseFold(is.CaseMapping, nick)
+	self := CaseFold(is.CaseMapping, c.CurrentNick())
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	if me {
+		for _, u := range c.state.users {
+			delete(u.channels, key)
+		}
+		c.pruneUsers(self)
+		return
+	}
+	if u, ok := c.state.users[nick]; ok {
+		delete(u.channels, key)
+		if len(u.channels) == 0 {
+			delete(c.state.users, nick)
+		}
+	}
+}
+
+// setWho updates a user from a WHO reply, users we don't share a channel
+// with are not added, only whether they are bots is remembered. Returns nil
+// for those, the state lock must be held
+func (c *Connection) setWho(is *ISupport, nick, user, host, flags, realname string) *trackedUser {
+	key := CaseFold(is.CaseMapping, nick)
+	u, ok := c.state.users[key]
+	if !ok {
+		if flags == "" {
+			return nil
+		}
+		if is.Bot != "" && strings.Contains(flags[1:], is.Bot) {
+			if len(c.state.whoBots) >= maxWhoBots {
+				c.state.whoBots = make(map[string]bool)
+			}
+			c.state.whoBots[key] = true
+		} else {
+			delete(c.state.whoBots, key)
+		}
+		return nil
+	}
+	if user != "" {
+		u.User.User = user
+	}
+	if host != "" {
+		u.Host = host
+	}
+	if realname != "" {
+		u.Realname = realname
+	}
+	// flags are "H" here or "G" gone, then * for opers, status prefixes and user modes
+	if flags != "" {
+		u.Away = flags[0] == 'G'
+		u.Bot = is.Bot != "" && strings.Contains(flags[1:], is.Bot)
+	}
+	return u
+}
+
+// trackUsers maintains the user table
+func (c *Connection) trackUsers() {
+	c.addHandler(JOIN, func(m *Message) {
+		if m.Prefix == nil || m.To == "" {
+			return
+		}
+		me, key := c.fromMe(m), c.Fold(m.To)
+		c.updateUser(m.Name, true, func(u *trackedUser) {
+			u.Nick = m.Name
+			u.User.User, u.Host = m.User, m.Host
+			u.channels[key] = m.To
+			// extended-join: "JOIN #chan account :realname"
+			if len(m.Params) > 2 {
+				u.Account = strings.TrimPrefix(m.Params[1], "*")
+				u.Realname = m.Params[2]
+			}
+		})
+		if me && c.SyncChannels {
+			go c.whoChannel(m.To)
+		}
+	})
+	c.addHandler(irc.RPL_NAMREPLY, func(m *Message) {
+		if len(m.Params) < 4 {
+			return
+		}
+		is := c.ISupport()
+		key := CaseFold(is.CaseMapping, m.Params[2])
+		c.state.mu.Lock()
+		defer c.state.mu.Unlock()
+		if _, ok := c.state.channels[key]; !ok {
+			return
+		}
+		for _, v := range strings.Fields(m.Trailing()) {
+			_, name := splitPrefixes(is, v)
+			prefix := irc.ParsePrefix(name)
+			u := c.user(is, prefix.Name)
+			u.channels[key] = m.Params[2]
+			// userhost-in-names
+			if prefix.IsHostmask() {
+				u.User.User, u.Host = prefix.User, prefix.Host
+			}
+		}
+	})
+	c.addHandler(irc.PART, func(m *Message) {
+		if m.Prefix == nil || len(m.Params) == 0 {
+			return
+		}
+		c.leaveChannel(m.Params[0], m.Name, c.fromMe(m))
+	})
+	c.addHandler(KICK, func(m *Message) {
+		if len(m.Params) < 2 {
+			return
+		}
+		c.leaveChannel(m.Params[0], m.Params[1], c.EqualFold(m.Params[1], c.CurrentNick()))
+	})
+	c.addHandler(irc.QUIT, func(m *Message) {
+		if m.Prefix == nil {
+			return
+		}
+		nick := c.Fold(m.Name)
+		c.state.mu.Lock()
+		delete(c.state.users, nick)
+		delete(c.state.whoBots, nick)
+		c.state.mu.Unlock()
+	})
+	c.addHandler(irc.NICK, func(m *Message) {
+		if m.Prefix == nil || len(m.Params) == 0 {
+			return
+		}
+		oldNick, newNick := c.Fold(m.Name), c.Fold(m.Params[0])
+		c.state.mu.Lock()
+		defer c.state.mu.Unlock()
+		if u, ok := c.state.users[oldNick]; ok {
+			delete(c.state.users, oldNick)
+			u.Nick = m.Params[0]
+			c.state.users[newNick] = u
+		}
+		if c.state.whoBots[oldNick] {
+			delete(c.state.whoBots, oldNick)
+			c.state.whoBots[newNick] = true
+		}
+	})
+	c.addHandler(CHGHOST, func(m *Message) {
+		if m.Prefix == nil || len(m.Params) < 2 {
+			return
+		}
+		c.updateUser(m.Name, false, func(u *trackedUser) {
+			u.User.User, u.Host = m.Params[0], m.Params[1]
+		})
+	})
+	c.addHandler(SETNAME, func(m *Message) {
+		if m.Prefix == nil || len(m.Params) == 0 {
+			return
+		}
+		c.updateUser(m.Name, false, func(u *trackedUser) {
+			u.Realname = m.Trailing()
+		})
+	})
+	c.addHandler(ACCOUNT, func(m *Message) {
+		// account-notify, "*" when logging out
+		if m.Prefix == nil || len(m.Params) == 0 {
+			return
+		}
+		c.updateUser(m.Name, false, func(u *trackedUser) {
+			u.Account = strings.TrimPrefix(m.Params[0], "*")
+		})
+	})
+	c.addHandler(irc.AWAY, func(m *Message) {
+		// away-notify, no message when back
+		if m.Prefix == nil {
+			return
+		}
+		c.updateUser(m.Name, false, func(u *trackedUser) {
+			u.Away = len(m.Params) > 0 && m.Trailing() != ""
+			u.AwayMessage = ""
+			if u.Away {
+				u.AwayMessage = m.Trailing()
+			}
+		})
+	})
+	c.addHandler(irc.RPL_AWAY, func(m *Message) {
+		// "301 me nick :message"
+		if len(m.Params) < 3 {
+			return
+		}
+		c.updateUser(m.Params[1], false, func(u *trackedUser) {
+			u.Away, u.AwayMessage = true, m.Trailing()
+		})
+	})
+	c.addHandler(irc.RPL_WHOREPLY, func(m *Message) {
+		// "352 me #chan user host server nick flags :hops realname"
+		if len(m.Params) < 8 {
+			return
+		}
+		realname := m.Params[7]
+		if i := strings.IndexByte(realname, ' '); i >= 0 {
+			realname = realname[i+1:]
+		} else {
+			realname = ""
+		}
+		is := c.ISupport()
+		c.state.mu.Lock()
+		defer c.state.mu.Unlock()
+		c.setWho(is, m.Params[5], m.Params[2], m.Params[3], m.Params[6], realname)
+	})
+	c.addHandler(RPL_WHOSPCRPL, func(m *Message) {
+		fields, ok := c.whoxFields(m)
+		if !ok {
+			return
+		}
+		v := parseWhoX(fields, m.Params)
+		if v['n'] == "" {
+			return
+		}
+		is := c.ISupport()
+		c.state.mu.Lock()
+		defer c.state.mu.Unlock()
+		u := c.setWho(is, v['n'], v['u'], v['h'], v['f'], v['r'])
+		if account, ok := v['a']; u != nil && ok {
+			u.Account = account
+			if account == "0" {
+				u.Account = ""
+			}
+		}
+	})
+	c.addHandler(irc.PRIVMSG, c.accountTag)
+	c.addHandler(irc.NOTICE, c.accountTag)
+}
+
+// accountTag updates the account of the sender from the account-tag capability
+func (c *Connection) accountTag(m *Message) {
+	account, ok := m.Tags["account"]
+	if !ok || m.Prefix == nil {
+		return
+	}
+	c.updateUser(m.Name, false, func(u *trackedUser) {
+		u.Account = account
+	})
+}
+
+func (c *Connection) isKnownBot(m *Message) bool {
+	if m.Prefix == nil {
+		return false
+	}
+	key := c.Fold(m.Name)
+	c.state.mu.RLock()
+	defer c.state.mu.RUnlock()
+	if u, ok := c.state.users[key]; ok {
+		return u.Bot
+	}
+	return c.state.whoBots[key]
+}
@@ -0,0 +1,90 @@
+package dumbirc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTrackUsers(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.SyncChannels = true
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	bot.Start()
+	srv.encode(fmt.Sprintf(":example.com 005 %s WHOX BOT=B :are supported", nick))
+	srv.encode(fmt.Sprintf(":%s!%s@example.com JOIN #test", nick, nick))
+	srv.encode(fmt.Sprintf(":example.com 353 %s = #test :@%s +voiced!v@voiced.example", nick, nick))
+	srv.encode(fmt.Sprintf(":example.com 366 %s #test :End of /NAMES list.", nick))
+	srv.encode(":joiner!j@joiner.example JOIN #test acc :Real Name")
+	srv.encode(fmt.Sprintf(":%s!%s@example.com JOIN #other", nick, nick))
+	srv.encode(":joiner!j@joiner.example JOIN #other acc :Real Name")
+	srv.encode(":joiner!j@joiner.example CHGHOST ident new.example")
+	srv.encode(":joiner!ident@new.example AWAY :lunch")
+	srv.encode(":joiner!ident@new.example NICK Renamed")
+	token := ""
+	for token == "" {
+		msg, err := srv.decode()
+		if err != nil {
+			t.Fatalf("decoding a message failed: %v", err)
+		}
+		// "WHO #test %tcuihsnfdlar,1"
+		if msg.Command == "WHO" && msg.Params[0] == "#test" && len(msg.Params) > 1 {
+			token = msg.Params[1][strings.IndexByte(msg.Params[1], ',')+1:]
+		}
+	}
+	srv.encode(fmt.Sprintf(":example.com 354 %s %s #test vuser 192.0.2.1 voiced.example irc.example.com voiced G+B 0 0 someacc :Voiced Person", nick, token))
+	srv.encode(fmt.Sprintf(":example.com 315 %s #test :End of /WHO list.", nick))
+	syncBot(srv, done)
+	expected := &User{
+		Nick:        "Renamed",
+		User:        "ident",
+		Host:        "new.example",
+		Realname:    "Real Name",
+		Account:     "acc",
+		Away:        true,
+		AwayMessage: "lunch",
+		Channels:    []string{"#other", "#test"},
+	}
+	if u := bot.LookupUser("renamed"); !reflect.DeepEqual(u, expected) {
+		t.Errorf("expected %+v, got %+v", expected, u)
+	}
+	expected = &User{
+		Nick:     "voiced",
+		User:     "vuser",
+		Host:     "voiced.example",
+		Realname: "Voiced Person",
+		Account:  "someacc",
+		Away:     true,
+		Bot:      true,
+		Channels: []string{"#test"},
+	}
+	if u := bot.LookupUser("voiced"); !reflect.DeepEqual(u, expected) {
+		t.Errorf("expected %+v, got %+v", expected, u)
+	}
+	srv.encode(fmt.Sprintf(":%s!%s@example.com PART #test", nick, nick))
+	srv.encode(":renamed!ident@new.example ACCOUNT *")
+	syncBot(srv, done)
+	if u := bot.LookupUser("voiced"); u != nil {
+		t.Errorf("expected voiced to be forgotten, got %+v", u)
+	}
+	if u := bot.LookupUser("renamed"); u == nil || u.Account != "" || !reflect.DeepEqual(u.Channels, []string{"#other"}) {
+		t.Errorf("expected renamed logged out in #other, got %+v", u)
+	}
+	srv.encode(":renamed!ident@new.example QUIT :bye")
+	syncBot(srv, done)
+	if u := bot.LookupUser("renamed"); u != nil {
+		t.Errorf("expected renamed to be forgotten after quit, got %+v", u)
+	}
+	if u := bot.LookupUser(nick); u == nil || u.Host != "example.com" {
+		t.Errorf("expected to know ourselves, got %+v", u)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,185 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// ErrNoWhoX is returned by WhoX when the server does not support WHOX
+var ErrNoWhoX = errors.New("server does not support WHOX")
+
+// WhoEntry is a single WHO or WHOX reply, fields that were not requested are empty
+type WhoEntry struct {
+	Channel string
+	User    string
+	IP      string
+	Host    string
+	Server  string
+	Nick    string
+	//H here or G gone, * for opers, then status prefixes
+	Flags    string
+	Away     bool
+	Hops     int
+	Idle     time.Duration
+	Account  string
+	OpLevel  string
+	Realname string
+}
+
+// WHOX reply fields in the order the server sends them
+const whoxOrder = "tcuihsnfdlaor"
+
+// whoxFields we ask for when the caller does not choose
+const whoxFields = "tcuihsnfdlar"
+
+type whoxState struct {
+	mu     sync.Mutex
+	next   int
+	tokens map[string]string
+	//plain WHO replies carry no token so we only run one at a time
+	whoMu sync.Mutex
+}
+
+// whoxQuery registers fields under a new query token
+func (c *Connection) whoxQuery(fields string) (token string) {
+	c.whox.mu.Lock()
+	defer c.whox.mu.Unlock()
+	// tokens are up to 3 digits
+	for {
+		c.whox.next = c.whox.next%999 + 1
+		token = strconv.Itoa(c.whox.next)
+		if _, ok := c.whox.tokens[token]; !ok {
+			break
+		}
+	}
+	c.whox.tokens[token] = fields
+	return token
+}
+
+func (c *Connection) whoxDone(token string) {
+	c.whox.mu.Lock()
+	delete(c.whox.tokens, token)
+	c.whox.mu.Unlock()
+}
+
+// whoxFields returns the fields requested for the 354 reply's query token
+func (c *Connection) whoxFields(m *Message) (fields string, ok bool) {
+	if len(m.Params) < 2 {
+		return "", false
+	}
+	c.whox.mu.Lock()
+	defer c.whox.mu.Unlock()
+	fields, ok = c.whox.tokens[m.Params[1]]
+	return
+}
+
+// parseWhoX maps 354 params to the requested fields
+func parseWhoX(fields string, params []string) map[byte]string {
+	values := make(map[byte]string)
+	// "354 me field1 field2 ..."
+	if len(params) > 0 {
+		params = params[1:]
+	}
+	for i := 0; i < len(whoxOrder) && len(params) > 0; i++ {
+		if strings.IndexByte(fields, whoxOrder[i]) < 0 {
+			continue
+		}
+		values[whoxOrder[i]] = params[0]
+		params = params[1:]
+	}
+	return values
+}
+
+func whoxEntry(v map[byte]string) WhoEntry {
+	e := WhoEntry{
+		Channel:  v['c'],
+		User:     v['u'],
+		IP:       v['i'],
+		Host:     v['h'],
+		Server:   v['s'],
+		Nick:     v['n'],
+		Flags:    v['f'],
+		Away:     strings.HasPrefix(v['f'], "G"),
+		OpLevel:  v['o'],
+		Realname: v['r'],
+	}
+	e.Hops, _ = strconv.Atoi(v['d'])
+	if sec, err := strconv.Atoi(v['l']); err == nil {
+		e.Idle = time.Duration(sec) * time.Second
+	}
+	if v['a'] != "0" {
+		e.Account = v['a']
+	}
+	return e
+}
+
+// Who lists the users matching a mask or in a channel,
+// uses WHOX when the server supports it to get accounts too
+func (c *Connection) Who(ctx context.Context, mask string) ([]WhoEntry, error) {
+	if c.ISupport().WhoX {
+		return c.WhoX(ctx, mask, whoxFields)
+	}
+	c.whox.whoMu.Lock()
+	defer c.whox.whoMu.Unlock()
+	var entries []WhoEntry
+	err := c.request(ctx, irc.WHO+" "+mask, func(m *Message) (bool, error) {
+		switch m.Command {
+		case irc.RPL_WHOREPLY:
+			// "352 me #chan user host server nick flags :hops realname"
+			if len(m.Params) < 8 {
+				return false, nil
+			}
+			p := m.Params
+			e := WhoEntry{Channel: p[1], User: p[2], Host: p[3], Server: p[4], Nick: p[5], Flags: p[6]}
+			e.Away = strings.HasPrefix(e.Flags, "G")
+			hops := p[7]
+			if i := strings.IndexByte(hops, ' '); i >= 0 {
+				hops, e.Realname = hops[:i], hops[i+1:]
+			}
+			e.Hops, _ = strconv.Atoi(hops)
+			entries = append(entries, e)
+		case irc.RPL_ENDOFWHO:
+			return len(m.Params) > 1 && c.EqualFold(m.Params[1], mask), nil
+		}
+		return false, nil
+	})
+	return entries, err
+}
+
+// WhoX lists the users matching a mask with the chosen WHOX fields,
+// any of "cuihsnfdlaor" e.g. "nua" for nick, user and account.
+// Returns ErrNoWhoX when the server does not support WHOX
+func (c *Connection) WhoX(ctx context.Context, mask, fields string) ([]WhoEntry, error) {
+	if !c.ISupport().WhoX {
+		return nil, ErrNoWhoX
+	}
+	// we need the token to tell our replies apart
+	if !strings.Contains(fields, "t") {
+		fields = "t" + fields
+	}
+	token := c.whoxQuery(fields)
+	defer c.whoxDone(token)
+	var entries []WhoEntry
+	cmd := irc.WHO + " " + mask + " %" + fields + "," + token
+	// 315 has no token and may end another query for the same mask,
+	// the fence ends ours
+	err := c.requestFenced(ctx, cmd, func(m *Message) {
+		if m.Command == RPL_WHOSPCRPL && len(m.Params) > 1 && m.Params[1] == token {
+			entries = append(entries, whoxEntry(parseWhoX(fields, m.Params)))
+		}
+	})
+	return entries, err
+}
+
+// whoChannel asks for the details of a channel's users after we join it
+func (c *Connection) whoChannel(channel string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.joinTimeout)
+	defer cancel()
+	c.Who(ctx, channel)
+}
@@ -0,0 +1,104 @@
+package dumbirc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWho(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	done := make(chan struct{})
+	bot.AddCallback("TESTSYNC", func(m *Message) {
+		done <- struct{}{}
+	})
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	srv.encode(fmt.Sprintf(":%s!%s@example.com JOIN #test", nick, nick))
+	srv.encode(fmt.Sprintf(":example.com 353 %s = #test :%s someone", nick, nick))
+	syncBot(srv, done)
+	type result struct {
+		entries []WhoEntry
+		err     error
+	}
+	results := make(chan result)
+	go func() {
+		entries, err := bot.Who(context.Background(), "#test")
+		results <- result{entries, err}
+	}()
+	if msg, _ := srv.decode(); msg.String() != "WHO #test" {
+		t.Fatalf("expected WHO #test, got %v", msg)
+	}
+	srv.encode(fmt.Sprintf(":example.com 352 %s #test user host.example irc.example.com someone G@ :2 Some One", nick))
+	srv.encode(fmt.Sprintf(":example.com 315 %s #test :End of /WHO list.", nick))
+	expected := []WhoEntry{{
+		Channel:  "#test",
+		User:     "user",
+		Host:     "host.example",
+		Server:   "irc.example.com",
+		Nick:     "someone",
+		Flags:    "G@",
+		Away:     true,
+		Hops:     2,
+		Realname: "Some One",
+	}}
+	if r := <-results; r.err != nil || !reflect.DeepEqual(r.entries, expected) {
+		t.Errorf("expected %+v, got %+v, %v", expected, r.entries, r.err)
+	}
+	if _, err := bot.WhoX(context.Background(), "#test", "na"); err != ErrNoWhoX {
+		t.Errorf("expected ErrNoWhoX, got %v", err)
+	}
+	srv.encode(fmt.Sprintf(":example.com 005 %s WHOX :are supported", nick))
+	syncBot(srv, done)
+	go func() {
+		entries, err := bot.WhoX(context.Background(), "#test", "nal")
+		results <- result{entries, err}
+	}()
+	if msg, _ := srv.decode(); msg.String() != "WHO #test %tnal,1" {
+		t.Fatalf("expected WHO #test %%tnal,1, got %v", msg)
+	}
+	srv.encode(fmt.Sprintf(":example.com 354 %s 999 other 0 acc", nick))
+	srv.encode(fmt.Sprintf(":example.com 354 %s 1 someone 30 acc", nick))
+	srv.encode(fmt.Sprintf(":example.com 354 %s 1 nobody 0 0", nick))
+	srv.encode(fmt.Sprintf(":example.com 315 %s #test :End of /WHO list.", nick))
+	ping, _ := srv.decode()
+	if ping.Command != "PING" {
+		t.Fatalf("expected a PING fence, got %v", ping)
+	}
+	srv.encode(fmt.Sprintf(":example.com PONG example.com :%s", ping.Trailing()))
+	expected = []WhoEntry{
+		{Nick: "someone", Idle: 30 * time.Second, Account: "acc"},
+		{Nick: "nobody"},
+	}
+	if r := <-results; r.err != nil || !reflect.DeepEqual(r.entries, expected) {
+		t.Errorf("expected %+v, got %+v, %v", expected, r.entries, r.err)
+	}
+	go func() {
+		entries, err := bot.WhoX(context.Background(), "#test", "n")
+		results <- result{entries, err}
+	}()
+	srv.decode()
+	ping, _ = srv.decode()
+	// the end of another query for the same channel
+	srv.encode(fmt.Sprintf(":example.com 315 %s #test :End of /WHO list.", nick))
+	srv.encode(fmt.Sprintf(":example.com 354 %s 2 someone", nick))
+	srv.encode(fmt.Sprintf(":example.com PONG example.com :%s", ping.Trailing()))
+	if r := <-results; r.err != nil || len(r.entries) != 1 {
+		t.Errorf("expected 1 entry, got %+v, %v", r.entries, r.err)
+	}
+	if u := bot.LookupUser("someone"); u == nil || u.Account != "acc" || !u.Away {
+		t.Errorf("expected WHO results in user tracking, got %+v", u)
+	}
+	if u := bot.LookupUser("nobody"); u != nil {
+		t.Errorf("expected users outside our channels not to be tracked, got %+v", u)
+	}
+	bot.Disconnect()
+	Destroy(bot)
+	srv.stop()
+}
@@ -0,0 +1,143 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// ErrNoSuchNick is returned when the server does not know the nick
+var ErrNoSuchNick = errors.New("no such nick")
+
+// WhoisInfo is the result of a WHOIS query
+type WhoisInfo struct {
+	Nick       string
+	User       string
+	Host       string
+	Realname   string
+	Server     string
+	ServerInfo string
+	Operator   bool
+	Idle       time.Duration
+	SignOn     time.Time
+	//Channels with status prefixes, e.g. "@#chan"
+	Channels []string
+	//Services account, empty when not logged in
+	Account string
+	//Connected with TLS
+	Secure bool
+	//TLS client certificate fingerprint
+	CertFP      string
+	Away        bool
+	AwayMessage string
+}
+
+// whoisState runs one WHOIS per nick at a time,
+// the replies can't tell two queries for the same nick apart
+type whoisState struct {
+	mu sync.Mutex
+	//closed when the query for the casefolded nick ends
+	running map[string]chan struct{}
+}
+
+// lock waits for the running query for nick to end
+func (w *whoisState) lock(ctx context.Context, key string) error {
+	for {
+		w.mu.Lock()
+		if w.running == nil {
+			w.running = make(map[string]chan struct{})
+		}
+		wait, ok := w.running[key]
+		if !ok {
+			w.running[key] = make(chan struct{})
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *whoisState) unlock(key string) {
+	w.mu.Lock()
+	close(w.running[key])
+	delete(w.running, key)
+	w.mu.Unlock()
+}
+
+// Whois queries information about a nick, the error is ErrNoSuchNick if there is no such user.
+// Queries for the same nick wait for each other
+func (c *Connection) Whois(ctx context.Context, nick string) (*WhoisInfo, error) {
+	key := c.Fold(nick)
+	if err := c.whois.lock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer c.whois.unlock(key)
+	info := &WhoisInfo{Nick: nick}
+	err := c.request(ctx, irc.WHOIS+" "+nick, func(m *Message) (bool, error) {
+		// all replies are "NUMERIC me nick ..."
+		if len(m.Params) < 2 || !c.EqualFold(m.Params[1], nick) {
+			return false, nil
+		}
+		p := m.Params
+		switch m.Command {
+		case irc.RPL_WHOISUSER:
+			// "311 me nick user host * :realname"
+			if len(p) > 5 {
+				info.Nick, info.User, info.Host, info.Realname = p[1], p[2], p[3], p[5]
+			}
+		case irc.RPL_WHOISSERVER:
+			if len(p) > 3 {
+				info.Server, info.ServerInfo = p[2], p[3]
+			}
+		case irc.RPL_WHOISOPERATOR:
+			info.Operator = true
+		case irc.RPL_WHOISIDLE:
+			// "317 me nick idle signon :seconds idle, signon time"
+			if len(p) > 2 {
+				if sec, err := strconv.Atoi(p[2]); err == nil {
+					info.Idle = time.Duration(sec) * time.Second
+				}
+			}
+			if len(p) > 4 {
+				if sec, err := strconv.ParseInt(p[3], 10, 64); err == nil {
+					info.SignOn = time.Unix(sec, 0)
+				}
+			}
+		case irc.RPL_WHOISCHANNELS:
+			info.Channels = append(info.Channels, strings.Fields(m.Trailing())...)
+		case RPL_WHOISACCOUNT:
+			// "330 me nick account :is logged in as"
+			if len(p) > 3 {
+				info.Account = p[2]
+			}
+		case RPL_WHOISSECURE:
+			info.Secure = true
+		case RPL_WHOISCERTFP:
+			// "276 me nick :has client certificate fingerprint FP"
+			if fields := strings.Fields(m.Trailing()); len(fields) > 0 {
+				info.CertFP = fields[len(fields)-1]
+			}
+		case irc.RPL_AWAY:
+			info.Away, info.AwayMessage = true, m.Trailing()
+		case irc.ERR_NOSUCHNICK:
+			return true, ErrorFromMessage(m)
+		case irc.RPL_ENDOFWHOIS:
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
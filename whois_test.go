@@ -0,0 +1,95 @@
+package dumbirc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWhois(t *testing.T) {
+	srv := newServer()
+	bot := New(nick, nick, SERVER, false)
+	bot.SetThrottle(0)
+	bot.Start()
+	for i := 0; i < 2; i++ {
+		srv.decode()
+	}
+	type result struct {
+		info *WhoisInfo
+		err  error
+	}
+	results := make(chan result)
+	go func() {
+		info, err := bot.Whois(context.Background(), "target")
+		results <- result{info, err}
+	}()
+	msg, _ := srv.decode()
+	if msg.String() != "WHOIS target" {
+		t.Fatalf("expected WHOIS target, got %v", msg)
+	}
+	for _, v := range []string{
+		":example.com 311 %s Target user host.example * :Real Name",
+		":example.com 319 %s other :#other",
+		":example.com 319 %s Target :@#test +#test2",
+		":example.com 312 %s Target irc.example.com :Example server",
+		":example.com 301 %s Target :gone fishing",
+		":example.com 313 %s Target :is an IRC operator",
+		":example.com 330 %s Target acc :is logged in as",
+		":example.com 671 %s Target :is using a secure connection",
+		":example.com 276 %s Target :has client certificate fingerprint abc123",
+		":example.com 317 %s Target 42 1547000000 :seconds idle, signon time",
+		":example.com 318 %s Target :End of /WHOIS list.",
+	} {
+		srv.encode(fmt.Sprintf(v, nick))
+	}
+	expected := &WhoisInfo{
+		Nick:        "Target",
+		User:        "user",
+		Host:        "host.example",
+		Realname:    "Real Name",
+		Server:      "irc.example.com",
+		ServerInfo:  "Example server",
+		Operator:    true,
+		Idle:        42 * time.Second,
+		SignOn:      time.Unix(1547000000, 0),
+		Channels:    []string{"@#test", "+#test2"},
+		Account:     "acc",
+		Secure:      true,
+		CertFP:      "abc123",
+		Away:        true,
+		AwayMessage: "gone fishing",
+	}
+	if r := <-results; r.err != nil || !reflect.DeepEqual(r.info, expected) {
+		t.Errorf("expected %+v, got %+v, %v", expected, r.info, r.err)
+	}
+	for i := 0; i < 2; i++ {
+		go func() {
+			info, err := bot.Whois(context.Background(), "nobody")
+			results <- result{info, err}
+		}()
+	}
+	// the second query waits for the first
+	for i := 0; i < 2; i++ {
+		if msg, _ := srv.decode(); msg.String() != "WHOIS nobody" {
+			t.Fatalf("expected WHOIS nobody, got %v", msg)
+		}
+		srv.encode(fmt.Sprintf(":example.com 401 %s nobody :No such nick/channel", nick))
+		if r := <-results; !errors.Is(r.err, ErrNoSuchNick) {
+			t.Errorf("expected ErrNoSuchNick, got %v", r.err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := bot.Whois(ctx, "slow"); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	bot.Disconnect()
+	if _, err := bot.Whois(context.Background(), "target"); err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	Destroy(bot)
+	srv.stop()
+}